@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// agentConn is an agent reached over a socket, closed with the client
+type agentConn struct {
+	agent.ExtendedAgent
+	io.Closer
+}
+
+// ConnectAgent connects to the SSH agent listening on SSH_AUTH_SOCK
+// The returned agent must be closed, which SSHClient.Close does for the
+// agent given to SetAgent.
+func ConnectAgent() (agent.ExtendedAgent, error) {
+	socket := os.Getenv("SSH_AUTH_SOCK")
+	if socket == "" {
+		return nil, fmt.Errorf("SSH_AUTH_SOCK is not set")
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SSH agent: %w", err)
+	}
+
+	return agentConn{ExtendedAgent: agent.NewClient(conn), Closer: conn}, nil
+}
+
+// SetAgent makes the client offer the agent's keys at the "agent" position
+// of the auth order
+// The agent is also the one served to the remote side when forwarding is
+// enabled, and is closed with the client if it is an io.Closer.
+func (c *SSHClient) SetAgent(ag agent.Agent) {
+	c.agent = ag
+}
+
+// EnableAgentForwarding forwards the agent on shell and command sessions
+func (c *SSHClient) EnableAgentForwarding() error {
+	if c.agent == nil {
+		return fmt.Errorf("agent forwarding requested but no SSH agent is available")
+	}
+	c.forwardAgent = true
+	return nil
+}
+
+// startAgentForwarding registers the agent as handler for forwarded agent
+// channels on the connection
+func (c *SSHClient) startAgentForwarding() error {
+	if !c.forwardAgent {
+		return nil
+	}
+	if err := agent.ForwardToAgent(c.client, c.agent); err != nil {
+		return fmt.Errorf("failed to set up agent forwarding: %w", err)
+	}
+	return nil
+}
+
+// requestAgentForwarding asks the server to forward the agent for the session
+func (c *SSHClient) requestAgentForwarding(session *ssh.Session) error {
+	if !c.forwardAgent {
+		return nil
+	}
+	if err := agent.RequestAgentForwarding(session); err != nil {
+		return fmt.Errorf("agent forwarding request failed: %w", err)
+	}
+	return nil
+}
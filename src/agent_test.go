@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// startTestServer runs an SSH server on a random local port that accepts
+// connections with config until the test ends, and returns its host, port
+// and host key
+func startTestServer(t *testing.T, config *ssh.ServerConfig) (string, string, ssh.PublicKey) {
+	t.Helper()
+	return startTestServerWithChannels(t, config, func(_ *ssh.ServerConn, newChannel ssh.NewChannel) {
+		newChannel.Reject(ssh.Prohibited, "no channels in tests")
+	})
+}
+
+// startTestServerWithChannels is startTestServer with a handler for the
+// channels clients open, which gets the connection to open channels back
+func startTestServerWithChannels(t *testing.T, config *ssh.ServerConfig, handle func(*ssh.ServerConn, ssh.NewChannel)) (string, string, ssh.PublicKey) {
+	t.Helper()
+
+	_, hostPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostPriv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				serverConn, chans, reqs, err := ssh.NewServerConn(conn, config)
+				if err != nil {
+					conn.Close()
+					return
+				}
+				go ssh.DiscardRequests(reqs)
+				for ch := range chans {
+					go handle(serverConn, ch)
+				}
+			}()
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	return host, port, hostSigner.PublicKey()
+}
+
+// testClient creates a client for the test server that pins its host key,
+// so nothing is read from or written to known_hosts
+func testClient(t *testing.T, host, port string, hostKey ssh.PublicKey, opts AuthOptions) *SSHClient {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+
+	client, err := NewSSHClientWithAuth(host, port, "tester", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.SetHostKeyOptions(HostKeyOptions{
+		Fingerprints: []string{ssh.FingerprintSHA256(hostKey)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestAgentAuthentication(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	userKey, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: priv, Comment: "test"}); err != nil {
+		t.Fatal(err)
+	}
+
+	host, port, hostKey := startTestServer(t, &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), userKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unknown key")
+		},
+	})
+
+	client := testClient(t, host, port, hostKey, AuthOptions{Order: []string{AuthMethodAgent}})
+	client.SetAgent(keyring)
+	if err := client.Connect(); err != nil {
+		t.Fatalf("Connect through the agent failed: %v", err)
+	}
+	defer client.Close()
+
+	want := "agent key " + ssh.FingerprintSHA256(userKey)
+	if got := client.AuthMethod(); got != want {
+		t.Errorf("AuthMethod() = %q, want %q", got, want)
+	}
+}
+
+func TestAgentAuthenticationWithoutKeys(t *testing.T) {
+	host, port, hostKey := startTestServer(t, &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			return nil, errors.New("unknown key")
+		},
+	})
+
+	client := testClient(t, host, port, hostKey, AuthOptions{Order: []string{AuthMethodAgent}})
+	client.SetAgent(agent.NewKeyring())
+	if err := client.Connect(); err == nil {
+		client.Close()
+		t.Fatal("Connect succeeded with an empty agent")
+	}
+}
+
+// closeRecorder is an agent that records being closed
+type closeRecorder struct {
+	agent.Agent
+	closed bool
+}
+
+func (c *closeRecorder) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestCloseClosesAgent(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	client, err := NewSSHClientWithAuth("127.0.0.1", "22", "tester", AuthOptions{Order: []string{AuthMethodAgent}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ag := &closeRecorder{Agent: agent.NewKeyring()}
+	client.SetAgent(ag)
+
+	client.Close()
+	if !ag.closed {
+		t.Error("Close did not close the agent connection")
+	}
+}
+
+func TestAgentForwarding(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: priv, Comment: "forwarded"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The server lists the keys of the forwarded agent when asked for it,
+	// like a remote ssh-add -l, then ends the command
+	listed := make(chan []*agent.Key, 1)
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	host, port, hostKey := startTestServerWithChannels(t, config, func(conn *ssh.ServerConn, newChannel ssh.NewChannel) {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "only sessions in tests")
+			return
+		}
+		session, reqs, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		defer session.Close()
+
+		for req := range reqs {
+			switch req.Type {
+			case "auth-agent-req@openssh.com":
+				req.Reply(true, nil)
+			case "exec":
+				req.Reply(true, nil)
+				var keys []*agent.Key
+				if ch, chReqs, err := conn.OpenChannel("auth-agent@openssh.com", nil); err == nil {
+					go ssh.DiscardRequests(chReqs)
+					keys, _ = agent.NewClient(ch).List()
+					ch.Close()
+				}
+				listed <- keys
+				session.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{0}))
+				return
+			default:
+				req.Reply(false, nil)
+			}
+		}
+	})
+
+	client := testClient(t, host, port, hostKey, AuthOptions{Password: "secret", Order: []string{AuthMethodPassword}})
+	client.SetAgent(keyring)
+	if err := client.EnableAgentForwarding(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err := client.RunCommand("ssh-add -l"); err != nil {
+		t.Fatalf("RunCommand error: %v", err)
+	}
+	keys := <-listed
+	if len(keys) != 1 || keys[0].Comment != "forwarded" {
+		t.Errorf("forwarded agent listed %v, want the keyring's key", keys)
+	}
+}
+
+func TestAgentForwardingWithoutAgent(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	client, err := NewSSHClientWithAuth("127.0.0.1", "22", "tester", AuthOptions{Order: []string{AuthMethodPassword}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetAgent(nil)
+	if err := client.EnableAgentForwarding(); err == nil {
+		t.Error("EnableAgentForwarding succeeded without an agent")
+	}
+}
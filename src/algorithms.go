@@ -0,0 +1,261 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// AlgorithmOptions restricts the algorithms offered during the handshake
+// Each field is a comma separated list in order of preference; empty
+// fields keep the library defaults. As in OpenSSH a list starting with
+// '+' appends to the defaults, '-' removes from them and '^' puts the
+// algorithms first, and names may contain '*' and '?' wildcards.
+type AlgorithmOptions struct {
+	Ciphers           string
+	KexAlgorithms     string
+	MACs              string
+	HostKeyAlgorithms string
+
+	// Lenient skips names that are not implemented with a warning, for
+	// lists from ~/.ssh/config that may be shared with newer OpenSSH
+	// versions; the lists fail only if nothing usable is left
+	Lenient bool
+}
+
+// Algorithm kinds, as accepted by sshclient algorithms
+const (
+	AlgorithmCipher  = "cipher"
+	AlgorithmKex     = "kex"
+	AlgorithmMAC     = "mac"
+	AlgorithmHostKey = "key"
+)
+
+// algorithmKinds lists the kinds in the order they are shown
+var algorithmKinds = []string{AlgorithmCipher, AlgorithmKex, AlgorithmMAC, AlgorithmHostKey}
+
+// algorithmKindNames describes the kinds in messages
+var algorithmKindNames = map[string]string{
+	AlgorithmCipher:  "cipher",
+	AlgorithmKex:     "key exchange algorithm",
+	AlgorithmMAC:     "MAC",
+	AlgorithmHostKey: "host key algorithm",
+}
+
+// algorithmKindTitles heads each kind in sshclient algorithms
+var algorithmKindTitles = map[string]string{
+	AlgorithmCipher:  "Ciphers",
+	AlgorithmKex:     "Key exchange algorithms",
+	AlgorithmMAC:     "MACs",
+	AlgorithmHostKey: "Host key algorithms",
+}
+
+// algorithmsOf returns the secure algorithms of a kind in order of
+// preference, which are the base for '+', '-' and '^' lists, and the
+// insecure ones that are only used when named explicitly
+func algorithmsOf(kind string) (supported, insecure []string) {
+	s, i := ssh.SupportedAlgorithms(), ssh.InsecureAlgorithms()
+	switch kind {
+	case AlgorithmCipher:
+		return s.Ciphers, i.Ciphers
+	case AlgorithmKex:
+		return s.KeyExchanges, i.KeyExchanges
+	case AlgorithmMAC:
+		return s.MACs, i.MACs
+	case AlgorithmHostKey:
+		return s.HostKeys, i.HostKeys
+	}
+	return nil, nil
+}
+
+// SetAlgorithms configures the ciphers, key exchange, MAC and host key
+// algorithms offered to the server
+func (c *SSHClient) SetAlgorithms(opts AlgorithmOptions) error {
+	ciphers, err := parseAlgorithmList(AlgorithmCipher, opts.Ciphers, opts.Lenient)
+	if err != nil {
+		return err
+	}
+	kex, err := parseAlgorithmList(AlgorithmKex, opts.KexAlgorithms, opts.Lenient)
+	if err != nil {
+		return err
+	}
+	macs, err := parseAlgorithmList(AlgorithmMAC, opts.MACs, opts.Lenient)
+	if err != nil {
+		return err
+	}
+	hostKeyAlgorithms, err := parseAlgorithmList(AlgorithmHostKey, opts.HostKeyAlgorithms, opts.Lenient)
+	if err != nil {
+		return err
+	}
+
+	c.config.Ciphers = ciphers
+	c.config.KeyExchanges = kex
+	c.config.MACs = macs
+	c.config.HostKeyAlgorithms = hostKeyAlgorithms
+	return nil
+}
+
+// parseAlgorithmList resolves a comma separated list, with an optional
+// '+', '-' or '^' modifier, against the algorithms implemented for kind
+// An empty list returns nil so the library defaults apply. Names that are
+// not implemented are an error, or only warned about when lenient.
+func parseAlgorithmList(kind, list string, lenient bool) ([]string, error) {
+	list = strings.TrimSpace(list)
+	if list == "" {
+		return nil, nil
+	}
+
+	supported, insecure := algorithmsOf(kind)
+	implemented := slices.Concat(supported, insecure)
+
+	modifier := list[0]
+	switch modifier {
+	case '+', '-', '^':
+		list = list[1:]
+	default:
+		modifier = 0
+	}
+
+	var named []string
+	for _, pattern := range strings.Split(list, ",") {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+		matched, err := matchAlgorithms(pattern, implemented)
+		if err != nil {
+			return nil, err
+		}
+		if len(matched) == 0 {
+			if !lenient {
+				return nil, fmt.Errorf("unsupported %s %q (see sshclient algorithms %s)", algorithmKindNames[kind], pattern, kind)
+			}
+			fmt.Fprintf(os.Stderr, "Warning: skipping unsupported %s %q\n", algorithmKindNames[kind], pattern)
+			continue
+		}
+		for _, name := range matched {
+			if !slices.Contains(named, name) {
+				named = append(named, name)
+			}
+		}
+	}
+
+	var algorithms []string
+	switch modifier {
+	case '+':
+		algorithms = slices.Clone(supported)
+		for _, name := range named {
+			if !slices.Contains(algorithms, name) {
+				algorithms = append(algorithms, name)
+			}
+		}
+	case '-':
+		algorithms = slices.DeleteFunc(slices.Clone(supported), func(name string) bool {
+			return slices.Contains(named, name)
+		})
+	case '^':
+		algorithms = named
+		for _, name := range supported {
+			if !slices.Contains(algorithms, name) {
+				algorithms = append(algorithms, name)
+			}
+		}
+	default:
+		algorithms = named
+	}
+
+	if len(algorithms) == 0 {
+		return nil, fmt.Errorf("no %ss left in %q", algorithmKindNames[kind], list)
+	}
+	return algorithms, nil
+}
+
+// matchAlgorithms returns the implemented algorithms matching a name or a
+// wildcard pattern, in order of preference
+func matchAlgorithms(pattern string, implemented []string) ([]string, error) {
+	var matched []string
+	for _, name := range implemented {
+		ok, err := path.Match(pattern, name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid algorithm pattern %q: %w", pattern, err)
+		}
+		if ok {
+			matched = append(matched, name)
+		}
+	}
+	return matched, nil
+}
+
+// ListAlgorithms prints the implemented algorithms of the given kinds,
+// secure ones in default order of preference followed by insecure ones
+func ListAlgorithms(kinds []string) {
+	for i, kind := range kinds {
+		if len(kinds) > 1 {
+			if i > 0 {
+				fmt.Println()
+			}
+			fmt.Printf("%s:\n", algorithmKindTitles[kind])
+		}
+
+		supported, insecure := algorithmsOf(kind)
+		for _, name := range supported {
+			fmt.Printf("  %s\n", name)
+		}
+		for _, name := range insecure {
+			fmt.Printf("  %s (insecure, only used when listed explicitly)\n", name)
+		}
+	}
+}
+
+// PrintAlgorithmsHelp prints help for the algorithms command
+func PrintAlgorithmsHelp() {
+	fmt.Println("Algorithms - List the algorithms sshclient can negotiate")
+	fmt.Println()
+	fmt.Println("Usage:")
+	fmt.Println("  sshclient algorithms [cipher|kex|mac|key]")
+	fmt.Println()
+	fmt.Println("Algorithms are chosen with the ciphers, kex_algorithms, macs and")
+	fmt.Println("host_key_algorithms profile settings, the -c, -kex, -m and")
+	fmt.Println("-host-key-algorithms flags or -o Ciphers=... and the like.")
+	fmt.Println()
+	fmt.Println("List modifiers:")
+	fmt.Println("  a,b        Use exactly these, in this order")
+	fmt.Println("  +a,b       Add to the defaults (e.g. to enable insecure algorithms)")
+	fmt.Println("  -a,b       Remove from the defaults")
+	fmt.Println("  ^a,b       Prefer these over the defaults")
+	fmt.Println("  Names may contain * and ? wildcards.")
+	fmt.Println("  Unknown names in ~/.ssh/config are skipped with a warning.")
+	fmt.Println()
+	fmt.Println("Examples:")
+	fmt.Println("  sshclient algorithms kex")
+	fmt.Println("  sshclient @switch -o KexAlgorithms=+diffie-hellman-group14-sha1 -c +aes128-cbc")
+	fmt.Println("  sshclient @prod -kex mlkem768x25519-sha256,curve25519-sha256")
+	fmt.Println("  sshclient @prod -m -hmac-sha1")
+}
+
+// HandleAlgorithmsCommand handles the algorithms command
+func HandleAlgorithmsCommand(args []string) error {
+	if len(args) < 1 {
+		ListAlgorithms(algorithmKinds)
+		return nil
+	}
+
+	switch kind := strings.ToLower(args[0]); kind {
+	case AlgorithmCipher, AlgorithmKex, AlgorithmMAC, AlgorithmHostKey:
+		ListAlgorithms([]string{kind})
+		return nil
+
+	case "help", "-h", "--help":
+		PrintAlgorithmsHelp()
+		return nil
+
+	default:
+		fmt.Printf("Unknown algorithm kind: %s\n\n", args[0])
+		PrintAlgorithmsHelp()
+		return fmt.Errorf("unknown algorithm kind: %s", args[0])
+	}
+}
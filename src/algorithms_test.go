@@ -0,0 +1,90 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseAlgorithmList(t *testing.T) {
+	supported, _ := algorithmsOf(AlgorithmCipher)
+	without := func(names ...string) []string {
+		return slices.DeleteFunc(slices.Clone(supported), func(name string) bool {
+			return slices.Contains(names, name)
+		})
+	}
+
+	tests := []struct {
+		name    string
+		list    string
+		lenient bool
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty keeps defaults", list: "", want: nil},
+		{name: "exact list", list: "aes256-ctr, aes128-ctr", want: []string{"aes256-ctr", "aes128-ctr"}},
+		{name: "duplicates", list: "aes128-ctr,aes128-ctr", want: []string{"aes128-ctr"}},
+		{name: "wildcard", list: "aes*-ctr", want: []string{"aes128-ctr", "aes192-ctr", "aes256-ctr"}},
+		{name: "wildcard ?", list: "aes1?8-ctr", want: []string{"aes128-ctr"}},
+		{name: "insecure named explicitly", list: "aes128-cbc", want: []string{"aes128-cbc"}},
+		{name: "append", list: "+aes128-cbc", want: append(slices.Clone(supported), "aes128-cbc")},
+		{name: "append existing", list: "+aes128-ctr", want: supported},
+		{name: "remove", list: "-aes128-ctr,chacha20*", want: without("aes128-ctr", "chacha20-poly1305@openssh.com")},
+		{name: "remove with wildcard", list: "-*-gcm@openssh.com", want: without("aes128-gcm@openssh.com", "aes256-gcm@openssh.com")},
+		{name: "prefer", list: "^aes256-ctr", want: append([]string{"aes256-ctr"}, without("aes256-ctr")...)},
+		{name: "prefer insecure", list: "^3des-cbc", want: append([]string{"3des-cbc"}, supported...)},
+		{name: "unknown", list: "aes128-ctr,twofish-cbc", wantErr: true},
+		{name: "unknown wildcard", list: "serpent*", wantErr: true},
+		{name: "bad pattern", list: "aes[", wantErr: true},
+		{name: "nothing left", list: "-*", wantErr: true},
+		{name: "lenient skips unknown", list: "twofish-cbc,aes128-ctr", lenient: true, want: []string{"aes128-ctr"}},
+		{name: "lenient prefer", list: "^aes512-ctr,aes256-ctr", lenient: true, want: append([]string{"aes256-ctr"}, without("aes256-ctr")...)},
+		{name: "lenient with nothing usable", list: "twofish-cbc,serpent-cbc", lenient: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseAlgorithmList(AlgorithmCipher, tt.list, tt.lenient)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseAlgorithmList(%q) = %v, want error", tt.list, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseAlgorithmList(%q) error: %v", tt.list, err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("parseAlgorithmList(%q) = %v, want %v", tt.list, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSSHConfigAlgorithmsAreLenient(t *testing.T) {
+	writeSSHConfig(t, map[string]string{
+		"config": "Host newer\n" +
+			"  Ciphers aes256-gcm@openssh.com,aes999-future\n",
+	})
+
+	profile, err := GetProfileFromSSHConfig("newer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !profile.FromSSHConfig {
+		t.Fatal("profile from ~/.ssh/config is not marked as such")
+	}
+
+	client := testClient(t, "127.0.0.1", "22", unusedHostKey(t), AuthOptions{})
+	opts := AlgorithmOptions{Ciphers: profile.Ciphers, Lenient: profile.FromSSHConfig}
+	if err := client.SetAlgorithms(opts); err != nil {
+		t.Fatalf("SetAlgorithms with an unknown cipher from ~/.ssh/config: %v", err)
+	}
+	if !slices.Equal(client.config.Ciphers, []string{"aes256-gcm@openssh.com"}) {
+		t.Errorf("ciphers = %v, want only the known one", client.config.Ciphers)
+	}
+
+	opts.Lenient = false
+	if err := client.SetAlgorithms(opts); err == nil {
+		t.Error("SetAlgorithms accepted an unknown cipher from the command line")
+	}
+}
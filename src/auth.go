@@ -0,0 +1,318 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// Authentication methods that can appear in a profile's auth_order
+const (
+	AuthMethodAgent       = "agent"        // Keys loaded in ssh-agent
+	AuthMethodDefaultKeys = "default-keys" // ~/.ssh/id_ed25519, id_ecdsa, id_rsa
+	AuthMethodKey         = "key"          // Key from the profile or -key
+	AuthMethodPassword    = "password"     // Stored password or prompt
+
+	AuthMethodKeyboardInteractive = "keyboard-interactive" // Server prompts (OTP/2FA)
+)
+
+// DefaultAuthOrder is the order in which authentication methods are tried
+// unless a profile overrides it
+var DefaultAuthOrder = []string{
+	AuthMethodAgent,
+	AuthMethodDefaultKeys,
+	AuthMethodKey,
+	AuthMethodPassword,
+	AuthMethodKeyboardInteractive,
+}
+
+// AuthOptions describes the authentication methods available for a connection
+type AuthOptions struct {
+	Order       []string // Method order, DefaultAuthOrder if empty
+	KeyPath     string   // Private key from the profile or -key
+	Passphrase  string   // Stored passphrase for KeyPath
+	Certificate string   // User certificate for KeyPath, <key>-cert.pub if empty
+	Password    string   // Stored password for password and keyboard-interactive prompts
+
+	IdentityFiles  []string // Additional keys offered after KeyPath
+	IdentitiesOnly bool     // Skip the agent and default keys
+}
+
+// ValidateAuthOrder checks that every entry is a known authentication method
+func ValidateAuthOrder(order []string) error {
+	for _, name := range order {
+		switch name {
+		case AuthMethodAgent, AuthMethodDefaultKeys, AuthMethodKey, AuthMethodPassword, AuthMethodKeyboardInteractive:
+		default:
+			return fmt.Errorf("unknown authentication method %q in auth_order", name)
+		}
+	}
+	return nil
+}
+
+// NewSSHClientWithAuth creates a new SSH client that tries every configured
+// authentication method in order until one succeeds
+func NewSSHClientWithAuth(host, port, user string, opts AuthOptions) (*SSHClient, error) {
+	order := opts.Order
+	if len(order) == 0 {
+		order = DefaultAuthOrder
+	}
+	if opts.IdentitiesOnly {
+		order = slices.DeleteFunc(slices.Clone(order), func(name string) bool {
+			return name == AuthMethodAgent || name == AuthMethodDefaultKeys
+		})
+	}
+	if err := ValidateAuthOrder(order); err != nil {
+		return nil, err
+	}
+
+	c, err := newSSHClient(host, port, user, order)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.KeyPath != "" && slices.Contains(order, AuthMethodKey) {
+		signers, err := c.loadKey(opts.KeyPath, opts.Passphrase, opts.Certificate)
+		if err != nil {
+			return nil, err
+		}
+		c.signers = signers
+	}
+
+	if slices.Contains(order, AuthMethodKey) {
+		for _, keyPath := range opts.IdentityFiles {
+			// Unlike the main key, missing extra keys are skipped like OpenSSH does
+			if _, err := os.Stat(keyPath); os.IsNotExist(err) {
+				continue
+			}
+			signer, err := loadKeySigner(keyPath, "", nil)
+			if err != nil {
+				return nil, err
+			}
+			signers, err := c.withCertificate(keyPath, "", signer)
+			if err != nil {
+				return nil, err
+			}
+			c.signers = append(c.signers, signers...)
+		}
+	}
+
+	if slices.Contains(order, AuthMethodDefaultKeys) {
+		for _, keyPath := range GetDefaultKeyPaths() {
+			if keyPath == opts.KeyPath || slices.Contains(opts.IdentityFiles, keyPath) {
+				continue
+			}
+			// Default keys are best effort: skip anything that cannot be loaded,
+			// including encrypted keys that would need a passphrase up front
+			signer, err := loadDefaultKeySigner(keyPath)
+			if err != nil {
+				continue
+			}
+			signers, err := c.withCertificate(keyPath, "", signer)
+			if err != nil {
+				continue
+			}
+			c.defaultKeys = append(c.defaultKeys, signers...)
+		}
+	}
+
+	c.password = opts.Password
+	c.promptPassword = opts.Password == ""
+
+	return c, nil
+}
+
+// loadKey loads the client's explicit key and its certificate, remembering
+// the passphrase that unlocked the key
+func (c *SSHClient) loadKey(keyPath, passphrase, certPath string) ([]ssh.Signer, error) {
+	signer, err := loadKeySigner(keyPath, passphrase, func(used string) {
+		c.keyPassphrase = used
+	})
+	if err != nil {
+		return nil, err
+	}
+	return c.withCertificate(keyPath, certPath, signer)
+}
+
+// AuthMethod returns a description of the method that completed authentication
+func (c *SSHClient) AuthMethod() string {
+	return c.authMethod
+}
+
+// authMethods builds the ssh auth methods in the configured order
+func (c *SSHClient) authMethods() []ssh.AuthMethod {
+	var methods []ssh.AuthMethod
+	publicKeyAdded := false
+
+	for _, name := range c.authOrder {
+		switch name {
+		case AuthMethodAgent, AuthMethodDefaultKeys, AuthMethodKey:
+			// The protocol only tries one publickey method per connection, so
+			// all key sources are offered through a single callback
+			if !publicKeyAdded {
+				methods = append(methods, c.publicKeyAuth())
+				publicKeyAdded = true
+			}
+		case AuthMethodPassword:
+			if c.password != "" || c.promptPassword {
+				methods = append(methods, ssh.PasswordCallback(c.passwordCallback))
+			}
+		case AuthMethodKeyboardInteractive:
+			methods = append(methods, ssh.KeyboardInteractive(c.keyboardInteractive))
+		}
+	}
+
+	return methods
+}
+
+// publicKeyAuth returns the publickey auth method for the client
+// Keys are offered in auth order and duplicates (e.g. a default key that is
+// also loaded in the agent) are only offered once
+func (c *SSHClient) publicKeyAuth() ssh.AuthMethod {
+	return ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		var signers []ssh.Signer
+		seen := make(map[string]bool)
+
+		add := func(signer ssh.Signer) {
+			key := string(signer.PublicKey().Marshal())
+			if !seen[key] {
+				seen[key] = true
+				signers = append(signers, signer)
+			}
+		}
+
+		for _, name := range c.authOrder {
+			switch name {
+			case AuthMethodAgent:
+				if c.agent == nil {
+					continue
+				}
+				// An unreachable agent should not prevent other keys from being tried
+				agentSigners, err := c.agent.Signers()
+				if err != nil {
+					continue
+				}
+				for _, signer := range agentSigners {
+					add(c.trackSigner("agent key "+ssh.FingerprintSHA256(signer.PublicKey()), signer))
+				}
+			case AuthMethodDefaultKeys:
+				for _, signer := range c.defaultKeys {
+					add(signer)
+				}
+			case AuthMethodKey:
+				for _, signer := range c.signers {
+					add(signer)
+				}
+			}
+		}
+
+		return signers, nil
+	})
+}
+
+// passwordCallback returns the stored password or prompts for one
+func (c *SSHClient) passwordCallback() (string, error) {
+	c.authMethod = AuthMethodPassword
+	if c.password != "" {
+		return c.password, nil
+	}
+
+	fmt.Printf("Password for %s@%s: ", c.config.User, c.host)
+	passwordBytes, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println() // New line after password input
+	if err != nil {
+		return "", fmt.Errorf("failed to read password: %w", err)
+	}
+	return string(passwordBytes), nil
+}
+
+// keyboardInteractive answers the server's challenges by showing each prompt
+// Input is hidden when the server disables echo. A password prompt is
+// answered once from the stored password, if there is one.
+func (c *SSHClient) keyboardInteractive(name, instruction string, questions []string, echos []bool) ([]string, error) {
+	c.authMethod = AuthMethodKeyboardInteractive
+
+	if name != "" {
+		fmt.Println(name)
+	}
+	if instruction != "" {
+		fmt.Println(instruction)
+	}
+
+	answers := make([]string, len(questions))
+
+	for i, question := range questions {
+		if !echos[i] && c.password != "" && !c.kbdPasswordSent && isPasswordPrompt(question) {
+			answers[i] = c.password
+			c.kbdPasswordSent = true
+			continue
+		}
+
+		fmt.Print(question)
+		if echos[i] {
+			answer, err := stdinReader.ReadString('\n')
+			if err != nil {
+				return nil, fmt.Errorf("failed to read answer: %w", err)
+			}
+			answers[i] = strings.TrimRight(answer, "\r\n")
+			continue
+		}
+
+		answer, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println() // New line after hidden input
+		if err != nil {
+			return nil, fmt.Errorf("failed to read answer: %w", err)
+		}
+		answers[i] = string(answer)
+	}
+
+	return answers, nil
+}
+
+// stdinReader reads line answers from standard input
+// Every prompt shares it, so input buffered while reading one answer is not
+// lost for the next one (a new bufio.Reader per prompt would drop it).
+var stdinReader = bufio.NewReader(os.Stdin)
+
+// isPasswordPrompt reports whether a keyboard-interactive prompt asks for the
+// account password (as opposed to an OTP code)
+func isPasswordPrompt(question string) bool {
+	return strings.Contains(strings.ToLower(question), "password")
+}
+
+// trackedSigner records which key was used when the server accepts it
+// The server only asks for a signature after accepting the public key, so
+// the last signer used is the one that authenticated the connection
+type trackedSigner struct {
+	ssh.Signer
+	label  string
+	client *SSHClient
+}
+
+// trackSigner wraps a signer so that signing records it as the auth method
+func (c *SSHClient) trackSigner(label string, signer ssh.Signer) ssh.Signer {
+	return &trackedSigner{Signer: signer, label: label, client: c}
+}
+
+func (s *trackedSigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	s.client.authMethod = s.label
+	return s.Signer.Sign(rand, data)
+}
+
+// SignWithAlgorithm keeps rsa-sha2-256/512 signatures available for RSA keys
+func (s *trackedSigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	s.client.authMethod = s.label
+	if as, ok := s.Signer.(ssh.AlgorithmSigner); ok {
+		return as.SignWithAlgorithm(rand, data, algorithm)
+	}
+	if algorithm != "" && algorithm != s.Signer.PublicKey().Type() {
+		return nil, fmt.Errorf("signature algorithm %s not supported by key", algorithm)
+	}
+	return s.Signer.Sign(rand, data)
+}
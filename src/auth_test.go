@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestKeyboardInteractiveSharesStdinReader(t *testing.T) {
+	saved := stdinReader
+	defer func() { stdinReader = saved }()
+
+	// Both answers arrive at once, as when pasted or piped
+	stdinReader = bufio.NewReader(strings.NewReader("alice\n123456\n"))
+	c := &SSHClient{}
+
+	for _, want := range []string{"alice", "123456"} {
+		answers, err := c.keyboardInteractive("", "", []string{"Answer: "}, []bool{true})
+		if err != nil {
+			t.Fatalf("keyboardInteractive error: %v", err)
+		}
+		if len(answers) != 1 || answers[0] != want {
+			t.Errorf("answers = %q, want [%q]", answers, want)
+		}
+	}
+}
+
+func TestKeyboardInteractiveStoredPassword(t *testing.T) {
+	saved := stdinReader
+	defer func() { stdinReader = saved }()
+	stdinReader = bufio.NewReader(strings.NewReader("654321\n"))
+
+	c := &SSHClient{password: "secret"}
+	answers, err := c.keyboardInteractive("", "", []string{"Password: ", "Verification code: "}, []bool{false, true})
+	if err != nil {
+		t.Fatalf("keyboardInteractive error: %v", err)
+	}
+	if len(answers) != 2 || answers[0] != "secret" || answers[1] != "654321" {
+		t.Errorf("answers = %q, want [secret 654321]", answers)
+	}
+}
+
+func TestKeyboardInteractivePasswordAfterReconnect(t *testing.T) {
+	host, port, hostKey := startTestServer(t, &ssh.ServerConfig{
+		KeyboardInteractiveCallback: func(conn ssh.ConnMetadata, challenge ssh.KeyboardInteractiveChallenge) (*ssh.Permissions, error) {
+			answers, err := challenge("", "", []string{"Password: "}, []bool{false})
+			if err != nil {
+				return nil, err
+			}
+			if len(answers) != 1 || answers[0] != "secret" {
+				return nil, errors.New("wrong password")
+			}
+			return nil, nil
+		},
+	})
+
+	client := testClient(t, host, port, hostKey, AuthOptions{Password: "secret", Order: []string{AuthMethodKeyboardInteractive}})
+	defer client.Close()
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Prompting would fail, stdin is not a terminal in tests
+	if err := client.Reconnect(ReconnectOptions{MaxAttempts: 1}); err != nil {
+		t.Fatalf("stored password was not sent again after reconnecting: %v", err)
+	}
+}
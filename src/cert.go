@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// LoadUserCertificate reads an OpenSSH user certificate (*-cert.pub)
+func LoadUserCertificate(certPath string) (*ssh.Certificate, error) {
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read certificate: %w", err)
+	}
+
+	pub, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse certificate %s: %w", certPath, err)
+	}
+
+	cert, ok := pub.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("%s is not an OpenSSH certificate", certPath)
+	}
+	if cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("%s is not a user certificate", certPath)
+	}
+
+	return cert, nil
+}
+
+// certificatePathForKey returns <key>-cert.pub if it exists next to the key
+func certificatePathForKey(keyPath string) string {
+	certPath := keyPath + "-cert.pub"
+	if _, err := os.Stat(certPath); err != nil {
+		return ""
+	}
+	return certPath
+}
+
+// CheckUserCertificate returns warnings for a certificate that the server
+// is likely to reject for the given login user
+func CheckUserCertificate(cert *ssh.Certificate, user string, now time.Time) []string {
+	var warnings []string
+
+	unix := uint64(now.Unix())
+	if cert.ValidAfter != 0 && unix < cert.ValidAfter {
+		warnings = append(warnings, fmt.Sprintf("certificate is not valid until %s", certTime(cert.ValidAfter)))
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity && unix >= cert.ValidBefore {
+		warnings = append(warnings, fmt.Sprintf("certificate expired at %s", certTime(cert.ValidBefore)))
+	}
+
+	// An empty principal list means the certificate is valid for any user
+	if len(cert.ValidPrincipals) > 0 && !slices.Contains(cert.ValidPrincipals, user) {
+		warnings = append(warnings, fmt.Sprintf("certificate principals %v do not include user %q", cert.ValidPrincipals, user))
+	}
+
+	return warnings
+}
+
+// certTime formats a certificate timestamp
+func certTime(t uint64) string {
+	return time.Unix(int64(t), 0).Format(time.RFC3339)
+}
+
+// withCertificate returns the signers to offer for a key: the certificate
+// signer first (if a certificate is available), then the plain key
+// certPath may be empty to pick up <key>-cert.pub automatically.
+func (c *SSHClient) withCertificate(keyPath, certPath string, signer ssh.Signer) ([]ssh.Signer, error) {
+	explicit := certPath != ""
+	if !explicit {
+		certPath = certificatePathForKey(keyPath)
+	}
+	if certPath == "" {
+		return []ssh.Signer{c.trackSigner("key "+keyPath, signer)}, nil
+	}
+
+	cert, err := LoadUserCertificate(certPath)
+	if err != nil {
+		if explicit {
+			return nil, err
+		}
+		// A broken auto-detected certificate should not block the plain key
+		fmt.Fprintf(os.Stderr, "Warning: ignoring %v\n", err)
+		return []ssh.Signer{c.trackSigner("key "+keyPath, signer)}, nil
+	}
+
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("certificate %s does not match key %s: %w", certPath, keyPath, err)
+	}
+
+	for _, warning := range CheckUserCertificate(cert, c.config.User, time.Now()) {
+		fmt.Fprintf(os.Stderr, "Warning: %s (%s)\n", warning, certPath)
+	}
+
+	return []ssh.Signer{
+		c.trackSigner("certificate "+certPath, certSigner),
+		c.trackSigner("key "+keyPath, signer),
+	}, nil
+}
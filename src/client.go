@@ -7,97 +7,307 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 	"golang.org/x/term"
 )
 
 // SSHClient represents an SSH client connection
 type SSHClient struct {
-	config *ssh.ClientConfig
-	client *ssh.Client
-	host   string
-	port   string
+	config    *ssh.ClientConfig
+	client    *ssh.Client
+	host      string
+	port      string
+	listeners []net.Listener
+	jump      *SSHClient // Previous hop when connecting through a jump host
+	proxyCmd  string     // Command whose stdin/stdout is used as the transport
+	hostKeys  HostKeyOptions
+
+	authOrder       []string     // Order in which authentication methods are tried
+	agent           agent.Agent  // SSH agent used for authentication and forwarding
+	signers         []ssh.Signer // Explicit key (profile or -key) and its certificate
+	defaultKeys     []ssh.Signer // Keys found in ~/.ssh
+	password        string       // Stored password
+	promptPassword  bool         // Ask for the password if the server wants one
+	kbdPasswordSent bool         // Stored password already used for a keyboard-interactive prompt
+	authMethod      string       // Method that completed authentication
+	forwardAgent    bool         // Forward the agent on shell and command sessions
+	keyPassphrase   string       // Passphrase that unlocked the private key
+
+	env map[string]string // Environment variables sent on shell and command sessions
+
+	keepAliveInterval time.Duration // Time between keepalives, 0 disables them
+	keepAliveCountMax int           // Unanswered keepalives before the connection is closed
+	connectionLost    atomic.Bool   // Set when the keepalive loop closed the connection
+
+	mu             sync.Mutex      // Guards client, which changes when reconnecting
+	remoteForwards []RemoteForward // Remote forwards to request again after reconnecting
+	controlPath    string          // Control master socket to connect through, if running
+
+	network   string       // tcp, tcp4 or tcp6, restricted by the address family
+	localAddr *net.TCPAddr // Local address to connect from, nil for any
 }
 
 // NewSSHClient creates a new SSH client with password authentication
+// The password is also used for keyboard-interactive password prompts,
+// other prompts (e.g. OTP codes) are asked on the terminal
 func NewSSHClient(host, port, user, password string) (*SSHClient, error) {
-	// Get host key callback for verification
-	hostKeyCallback, err := GetHostKeyCallback()
+	c, err := newSSHClient(host, port, user, []string{AuthMethodAgent, AuthMethodPassword, AuthMethodKeyboardInteractive})
 	if err != nil {
-		return nil, fmt.Errorf("failed to setup host key verification: %w", err)
+		return nil, err
 	}
+	c.password = password
 
-	config := &ssh.ClientConfig{
-		User: user,
-		Auth: []ssh.AuthMethod{
-			ssh.Password(password),
-		},
-		HostKeyCallback: hostKeyCallback,
-		Timeout:         10 * time.Second,
-	}
-
-	return &SSHClient{
-		config: config,
-		host:   host,
-		port:   port,
-	}, nil
+	return c, nil
 }
 
 // NewSSHClientWithKey creates a new SSH client with key-based authentication
 func NewSSHClientWithKey(host, port, user, keyPath string) (*SSHClient, error) {
-	// Read private key file
-	key, err := ioutil.ReadFile(keyPath)
+	return NewSSHClientWithKeyPassphrase(host, port, user, keyPath, "")
+}
+
+// NewSSHClientWithKeyPassphrase creates a new SSH client with key-based
+// authentication, using the passphrase if the key is encrypted
+// The user is prompted when the passphrase is empty or incorrect
+func NewSSHClientWithKeyPassphrase(host, port, user, keyPath, passphrase string) (*SSHClient, error) {
+	c, err := newSSHClient(host, port, user, []string{AuthMethodAgent, AuthMethodKey})
 	if err != nil {
-		return nil, fmt.Errorf("failed to read private key: %w", err)
+		return nil, err
 	}
 
-	// Parse private key
-	signer, err := ssh.ParsePrivateKey(key)
+	// A <key>-cert.pub next to the key is offered as well
+	signers, err := c.loadKey(keyPath, passphrase, "")
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse private key: %w", err)
+		return nil, err
 	}
+	c.signers = signers
 
+	return c, nil
+}
+
+// newSSHClient creates a client with host key verification whose
+// authentication methods are tried in the given order
+func newSSHClient(host, port, user string, authOrder []string) (*SSHClient, error) {
 	// Get host key callback for verification
-	hostKeyCallback, err := GetHostKeyCallback()
+	hostKeyCallback, err := GetHostKeyCallback(HostKeyOptions{})
 	if err != nil {
 		return nil, fmt.Errorf("failed to setup host key verification: %w", err)
 	}
 
 	config := &ssh.ClientConfig{
-		User: user,
-		Auth: []ssh.AuthMethod{
-			ssh.PublicKeys(signer),
-		},
+		User:            user,
 		HostKeyCallback: hostKeyCallback,
-		Timeout:         10 * time.Second,
+		Timeout:         DefaultConnectTimeout,
 	}
 
 	return &SSHClient{
-		config: config,
-		host:   host,
-		port:   port,
+		config:    config,
+		host:      host,
+		port:      port,
+		authOrder: authOrder,
 	}, nil
 }
 
+// KeyPassphrase returns the passphrase that unlocked the client's key
+// (empty if the key is not encrypted)
+func (c *SSHClient) KeyPassphrase() string {
+	return c.keyPassphrase
+}
+
+// SetHostKeyOptions replaces the client's host key verification settings
+func (c *SSHClient) SetHostKeyOptions(opts HostKeyOptions) error {
+	hostKeyCallback, err := GetHostKeyCallback(opts)
+	if err != nil {
+		return fmt.Errorf("failed to setup host key verification: %w", err)
+	}
+	opts.UpdateHostKeys, err = ParseUpdateHostKeys(opts.UpdateHostKeys)
+	if err != nil {
+		return err
+	}
+
+	c.config.HostKeyCallback = hostKeyCallback
+	c.hostKeys = opts
+	return nil
+}
+
+// SetConnectTimeout sets how long to wait for the connection to the server
+// (DefaultConnectTimeout when zero)
+func (c *SSHClient) SetConnectTimeout(timeout time.Duration) {
+	if timeout <= 0 {
+		timeout = DefaultConnectTimeout
+	}
+	c.config.Timeout = timeout
+}
+
+// SetJump makes the client connect through an already configured jump host
+// The jump host is connected first and closed together with this client
+func (c *SSHClient) SetJump(jump *SSHClient) {
+	c.jump = jump
+}
+
+// SetProxyCommand makes the client use a child process's stdin and stdout
+// as the transport instead of a TCP connection (tokens must already be expanded)
+func (c *SSHClient) SetProxyCommand(command string) {
+	c.proxyCmd = command
+}
+
 // Connect establishes the SSH connection
 func (c *SSHClient) Connect() error {
+	// Share the control master's connection when one is running
+	if c.controlPath != "" && c.connectControl() == nil {
+		c.startKeepAlive()
+		return nil
+	}
+
 	addr := net.JoinHostPort(c.host, c.port)
-	client, err := ssh.Dial("tcp", addr, c.config)
+	c.config.Auth = c.authMethods()
+	c.kbdPasswordSent = false // The stored password may be sent again
+
+	conn, err := c.dial(addr)
 	if err != nil {
 		return fmt.Errorf("failed to dial: %w", err)
 	}
-	c.client = client
+
+	// Remember the accepted host key so announced key rotations can be checked
+	var hostKey ssh.PublicKey
+	config := *c.config
+	recordHostKey := hostKeyRecorder(c.config.HostKeyCallback, &hostKey)
+
+	// The server must get to showing its host key within the timeout
+	// Confirming the key and authenticating may prompt the user, so the
+	// deadline ends there.
+	deadline := newHandshakeDeadline(conn, c.config.Timeout)
+	config.HostKeyCallback = func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		deadline.clear()
+		return recordHostKey(hostname, remote, key)
+	}
+
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, addr, &config)
+	deadline.clear()
+	if err != nil {
+		conn.Close()
+		if deadline.expired() {
+			return fmt.Errorf("failed to dial: handshake with %s timed out after %s", addr, c.config.Timeout)
+		}
+		return fmt.Errorf("failed to dial: %w", err)
+	}
+
+	reqs = c.filterHostKeyRequests(sshConn, addr, hostKey, reqs)
+	c.setClient(ssh.NewClient(sshConn, chans, reqs))
+
+	if err := c.startAgentForwarding(); err != nil {
+		c.client.Close()
+		c.setClient(nil)
+		return err
+	}
+
+	c.startKeepAlive()
 	return nil
 }
 
-// Close closes the SSH connection
+// handshakeDeadline closes a connection whose handshake has not got far
+// enough in time
+// Connections through a jump host or a proxy command do not support
+// deadlines, so the connection is closed instead of setting one.
+type handshakeDeadline struct {
+	timer *time.Timer
+	fired atomic.Bool
+}
+
+func newHandshakeDeadline(conn net.Conn, timeout time.Duration) *handshakeDeadline {
+	d := &handshakeDeadline{}
+	d.timer = time.AfterFunc(timeout, func() {
+		d.fired.Store(true)
+		conn.Close()
+	})
+	return d
+}
+
+// clear stops the deadline, it may be called more than once
+func (d *handshakeDeadline) clear() {
+	d.timer.Stop()
+}
+
+// expired reports whether the connection was closed by the deadline
+func (d *handshakeDeadline) expired() bool {
+	return d.fired.Load()
+}
+
+// setClient replaces the connection used by sessions and forwards
+func (c *SSHClient) setClient(client *ssh.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.client = client
+}
+
+// currentClient returns the connection for use from forwarding goroutines
+// (nil while disconnected)
+func (c *SSHClient) currentClient() *ssh.Client {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.client
+}
+
+// dial opens the transport connection to addr, either through the jump
+// host, through the proxy command or directly
+func (c *SSHClient) dial(addr string) (net.Conn, error) {
+	if c.jump != nil && c.proxyCmd != "" {
+		return nil, fmt.Errorf("jump hosts and a proxy command cannot be used together")
+	}
+	if c.jump == nil {
+		if c.proxyCmd != "" {
+			return dialProxyCommand(c.proxyCmd)
+		}
+		return c.dialTCP(addr)
+	}
+
+	if c.jump.client == nil {
+		if err := c.jump.Connect(); err != nil {
+			return nil, fmt.Errorf("jump host %s: %w", net.JoinHostPort(c.jump.host, c.jump.port), err)
+		}
+	}
+
+	conn, err := c.jump.client.Dial("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("jump host %s: %w", net.JoinHostPort(c.jump.host, c.jump.port), err)
+	}
+	return conn, nil
+}
+
+// Close closes the SSH connection, any active port forwards and the jump hosts
 func (c *SSHClient) Close() error {
+	for _, listener := range c.listeners {
+		listener.Close()
+	}
+	c.listeners = nil
+
+	var err error
 	if c.client != nil {
-		return c.client.Close()
+		err = c.client.Close()
 	}
-	return nil
+	if c.jump != nil {
+		c.jump.Close()
+	}
+	if closer, ok := c.agent.(io.Closer); ok {
+		closer.Close()
+	}
+	return err
+}
+
+// Wait blocks until the SSH connection is closed
+func (c *SSHClient) Wait() error {
+	if c.client == nil {
+		return fmt.Errorf("not connected")
+	}
+	err := c.client.Wait()
+	if c.connectionLost.Load() {
+		return ErrConnectionLost
+	}
+	return err
 }
 
 // RunCommand executes a single command on the remote server
@@ -112,8 +322,16 @@ func (c *SSHClient) RunCommand(cmd string) (string, error) {
 	}
 	defer session.Close()
 
+	if err := c.requestAgentForwarding(session); err != nil {
+		return "", err
+	}
+	c.setSessionEnv(session)
+
 	output, err := session.CombinedOutput(cmd)
 	if err != nil {
+		if c.connectionLost.Load() {
+			return string(output), ErrConnectionLost
+		}
 		return string(output), fmt.Errorf("command failed: %w", err)
 	}
 
@@ -122,6 +340,18 @@ func (c *SSHClient) RunCommand(cmd string) (string, error) {
 
 // StartInteractiveShell starts an interactive shell session
 func (c *SSHClient) StartInteractiveShell() error {
+	return c.runTerminalSession("")
+}
+
+// RunCommandWithTTY runs a command on a pseudo terminal attached to the
+// local terminal, for commands that need one (RequestTTY yes or force)
+func (c *SSHClient) RunCommandWithTTY(cmd string) error {
+	return c.runTerminalSession(cmd)
+}
+
+// runTerminalSession runs a command, or the login shell if cmd is empty,
+// on a pseudo terminal attached to the local terminal
+func (c *SSHClient) runTerminalSession(cmd string) error {
 	if c.client == nil {
 		return fmt.Errorf("not connected")
 	}
@@ -132,6 +362,11 @@ func (c *SSHClient) StartInteractiveShell() error {
 	}
 	defer session.Close()
 
+	if err := c.requestAgentForwarding(session); err != nil {
+		return err
+	}
+	c.setSessionEnv(session)
+
 	// Set up terminal modes
 	modes := ssh.TerminalModes{
 		ssh.ECHO:          1,     // enable echoing
@@ -140,12 +375,15 @@ func (c *SSHClient) StartInteractiveShell() error {
 	}
 
 	// Get terminal size
+	// Commands forced onto a pseudo terminal may run without a local one
 	fd := int(os.Stdin.Fd())
-	state, err := term.MakeRaw(fd)
-	if err != nil {
-		return fmt.Errorf("failed to make terminal raw: %w", err)
+	if cmd == "" || term.IsTerminal(fd) {
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return fmt.Errorf("failed to make terminal raw: %w", err)
+		}
+		defer term.Restore(fd, state)
 	}
-	defer term.Restore(fd, state)
 
 	w, h, err := term.GetSize(fd)
 	if err != nil {
@@ -160,15 +398,42 @@ func (c *SSHClient) StartInteractiveShell() error {
 	// Set up I/O
 	session.Stdout = os.Stdout
 	session.Stderr = os.Stderr
-	session.Stdin = os.Stdin
-
-	// Start shell
-	if err := session.Shell(); err != nil {
-		return fmt.Errorf("failed to start shell: %w", err)
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open session stdin: %w", err)
+	}
+
+	// Stop feeding input before returning, so that what is typed after a
+	// connection drop goes to the reconnect prompts and the next session
+	done := make(chan struct{})
+	fed := make(chan struct{})
+	go func() {
+		terminalStdin.feed(stdin, done)
+		close(fed)
+	}()
+	defer func() {
+		close(done)
+		session.Close() // Unblocks a write to a stalled session
+		<-fed
+	}()
+
+	// Start shell or command
+	if cmd == "" {
+		if err := session.Shell(); err != nil {
+			return fmt.Errorf("failed to start shell: %w", err)
+		}
+	} else if err := session.Start(cmd); err != nil {
+		return fmt.Errorf("failed to start command: %w", err)
 	}
 
 	// Wait for session to finish
 	if err := session.Wait(); err != nil {
+		if c.connectionLost.Load() {
+			return ErrConnectionLost
+		}
+		if cmd != "" {
+			return fmt.Errorf("command failed: %w", err)
+		}
 		if _, ok := err.(*ssh.ExitError); ok {
 			return nil // Normal exit
 		}
@@ -180,25 +445,39 @@ func (c *SSHClient) StartInteractiveShell() error {
 
 // GetDefaultKeyPath returns the default SSH key path
 func GetDefaultKeyPath() string {
+	keys := GetDefaultKeyPaths()
+	if len(keys) == 0 {
+		return ""
+	}
+	return keys[0]
+}
+
+// GetDefaultKeyPaths returns every readable default SSH key in preference order
+func GetDefaultKeyPaths() []string {
 	home, err := os.UserHomeDir()
 	if err != nil {
-		return ""
+		return nil
 	}
 
 	// Check common key locations
 	keys := []string{
-		filepath.Join(home, ".ssh", "id_rsa"),
 		filepath.Join(home, ".ssh", "id_ed25519"),
 		filepath.Join(home, ".ssh", "id_ecdsa"),
+		filepath.Join(home, ".ssh", "id_rsa"),
 	}
 
+	// Skip keys that are missing or unreadable (e.g. wrong permissions)
+	var found []string
 	for _, key := range keys {
-		if _, err := os.Stat(key); err == nil {
-			return key
+		f, err := os.Open(key)
+		if err != nil {
+			continue
 		}
+		f.Close()
+		found = append(found, key)
 	}
 
-	return ""
+	return found
 }
 
 // CopyFile copies a file to the remote server using SCP
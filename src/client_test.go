@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// startStalledServer accepts TCP connections and never sends anything,
+// like a server that hangs before the SSH handshake
+func startStalledServer(t *testing.T) (string, string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	return host, port
+}
+
+// unusedHostKey returns a host key for pinning servers that never show one
+func unusedHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// connectWithin connects the client and fails the test if that takes
+// longer than limit or succeeds
+func connectWithin(t *testing.T, client *SSHClient, limit time.Duration) error {
+	t.Helper()
+
+	result := make(chan error, 1)
+	go func() { result <- client.Connect() }()
+	select {
+	case err := <-result:
+		if err == nil {
+			client.Close()
+			t.Fatal("Connect to a stalled server succeeded")
+		}
+		return err
+	case <-time.After(limit):
+		t.Fatal("Connect to a stalled server did not time out")
+		return nil
+	}
+}
+
+func TestHandshakeTimeout(t *testing.T) {
+	host, port := startStalledServer(t)
+
+	client := testClient(t, host, port, unusedHostKey(t), AuthOptions{Password: "secret", Order: []string{AuthMethodPassword}})
+	client.SetConnectTimeout(200 * time.Millisecond)
+
+	err := connectWithin(t, client, 5*time.Second)
+	if !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Connect error = %v, want a handshake timeout", err)
+	}
+}
+
+func TestHandshakeTimeoutThroughJumpHost(t *testing.T) {
+	targetHost, targetPort := startStalledServer(t)
+
+	// The jump host forwards to the stalled server, which never answers
+	jumpHost, jumpPort, jumpKey := startForwardingServer(t)
+	jump := testClient(t, jumpHost, jumpPort, jumpKey, AuthOptions{Password: "secret", Order: []string{AuthMethodPassword}})
+	defer jump.Close()
+
+	client := testClient(t, targetHost, targetPort, unusedHostKey(t), AuthOptions{Password: "secret", Order: []string{AuthMethodPassword}})
+	client.SetConnectTimeout(200 * time.Millisecond)
+	client.SetJump(jump)
+
+	err := connectWithin(t, client, 5*time.Second)
+	if !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Connect error = %v, want a handshake timeout", err)
+	}
+}
+
+// startForwardingServer runs an SSH server accepting the password "secret"
+// that connects direct-tcpip channels to their target, like a jump host
+func startForwardingServer(t *testing.T) (string, string, ssh.PublicKey) {
+	t.Helper()
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, errors.New("wrong password")
+		},
+	}
+	return startTestServerWithChannels(t, config, func(_ *ssh.ServerConn, newChannel ssh.NewChannel) {
+		var target struct {
+			Host       string
+			Port       uint32
+			OriginHost string
+			OriginPort uint32
+		}
+		if newChannel.ChannelType() != "direct-tcpip" || ssh.Unmarshal(newChannel.ExtraData(), &target) != nil {
+			newChannel.Reject(ssh.Prohibited, "only direct-tcpip in tests")
+			return
+		}
+		conn, err := net.Dial("tcp", net.JoinHostPort(target.Host, fmt.Sprint(target.Port)))
+		if err != nil {
+			newChannel.Reject(ssh.ConnectionFailed, err.Error())
+			return
+		}
+		channel, reqs, err := newChannel.Accept()
+		if err != nil {
+			conn.Close()
+			return
+		}
+		go ssh.DiscardRequests(reqs)
+		go func() {
+			io.Copy(channel, conn)
+			channel.Close()
+		}()
+		io.Copy(conn, channel)
+		conn.Close()
+	})
+}
+
+func TestReconnectDoesNotDuplicateKnownHost(t *testing.T) {
+	host, port, _ := startTestServer(t, &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	})
+
+	t.Setenv("HOME", t.TempDir())
+	knownHosts := filepath.Join(t.TempDir(), "known_hosts")
+	client, err := NewSSHClientWithAuth(host, port, "tester", AuthOptions{Password: "secret", Order: []string{AuthMethodPassword}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = client.SetHostKeyOptions(HostKeyOptions{StrictHostKeyChecking: StrictHostKeyAcceptNew, KnownHostsFile: knownHosts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if err := client.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Reconnect(ReconnectOptions{MaxAttempts: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	lines, err := readKnownHosts(knownHosts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 1 {
+		t.Errorf("known_hosts has %d entries after a reconnect, want 1", len(lines))
+	}
+}
@@ -1,24 +1,100 @@
 package main
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 // Profile represents an SSH connection profile
 type Profile struct {
-	Name              string `yaml:"-"`
-	Host              string `yaml:"host"`
-	User              string `yaml:"user"`
-	Port              string `yaml:"port,omitempty"`
-	Key               string `yaml:"key,omitempty"`
-	Password          string `yaml:"password,omitempty"`           // Deprecated: plain text password
-	EncryptedPassword string `yaml:"encrypted_password,omitempty"` // Encrypted password (AES-256-GCM)
+	Name              string   `yaml:"-"`
+	Host              string   `yaml:"host"`
+	User              string   `yaml:"user"`
+	Port              string   `yaml:"port,omitempty"`
+	Key               string   `yaml:"key,omitempty"`
+	Certificate       string   `yaml:"certificate,omitempty"`        // User certificate, defaults to <key>-cert.pub
+	Password          string   `yaml:"password,omitempty"`           // Deprecated: plain text password
+	EncryptedPassword string   `yaml:"encrypted_password,omitempty"` // Encrypted password (AES-256-GCM)
+	Forwards          []string `yaml:"forwards,omitempty"`           // Local forwards: [bind_address:]port:host:hostport
+	RemoteForwards    []string `yaml:"remote_forwards,omitempty"`    // Remote forwards: [bind_address:]port:host:hostport
+	DynamicForwards   []string `yaml:"dynamic_forwards,omitempty"`   // SOCKS5 proxies: [bind_address:]port
+	SocksUser         string   `yaml:"socks_user,omitempty"`         // Username required by the local SOCKS5 proxy
+	SocksPassword     string   `yaml:"socks_password,omitempty"`     // Deprecated: plain text SOCKS5 proxy password
+	Jump              []string `yaml:"jump,omitempty"`               // Jump hosts in order: @profile or [user@]host[:port]
+	ProxyCommand      string   `yaml:"proxy_command,omitempty"`      // Command used as transport (%h, %p, %r, %n expanded)
+	ForwardAgent      bool     `yaml:"forward_agent,omitempty"`      // Forward the SSH agent on shell and command sessions
+	HostCA            []string `yaml:"host_ca,omitempty"`            // Trusted host CA public keys or key files
+
+	StrictHostKeyChecking string `yaml:"strict_host_key_checking,omitempty"` // yes, accept-new, ask (default) or off
+	HashKnownHosts        bool   `yaml:"hash_known_hosts,omitempty"`         // Store new known_hosts entries hashed
+	UpdateHostKeys        string `yaml:"update_host_keys,omitempty"`         // Learn host keys announced by the server: yes (default) or no
+
+	HostKeyFingerprints []string `yaml:"host_key_fingerprints,omitempty"` // Pinned SHA256 host key fingerprints (no known_hosts, no prompt)
+	KnownHostsFile      string   `yaml:"known_hosts_file,omitempty"`      // known_hosts used instead of ~/.sshclient/known_hosts
+
+	IdentityFiles  []string `yaml:"identity_files,omitempty"`  // Additional private keys, offered after key
+	IdentitiesOnly bool     `yaml:"identities_only,omitempty"` // Only offer key and identity_files (no agent or default keys)
+
+	ServerAliveInterval int    `yaml:"server_alive_interval,omitempty"`  // Seconds between keepalive messages (0 disables them)
+	ServerAliveCountMax int    `yaml:"server_alive_count_max,omitempty"` // Unanswered keepalives before the connection is considered lost
+	ConnectTimeout      int    `yaml:"connect_timeout,omitempty"`        // Seconds to wait for the connection to be established
+	AddressFamily       string `yaml:"address_family,omitempty"`         // any (default), inet (IPv4 only) or inet6 (IPv6 only)
+	BindAddress         string `yaml:"bind_address,omitempty"`           // Local address to connect from
+	BindInterface       string `yaml:"bind_interface,omitempty"`         // Local interface whose address is used to connect from
+
+	Reconnect            bool   `yaml:"reconnect,omitempty"`              // Reconnect with backoff when the connection drops
+	ReconnectMaxAttempts int    `yaml:"reconnect_max_attempts,omitempty"` // Attempts per outage before giving up (0: no limit)
+	ReconnectMaxDuration int    `yaml:"reconnect_max_duration,omitempty"` // Seconds per outage before giving up (0: no limit)
+	Reattach             string `yaml:"reattach,omitempty"`               // Interactive session after reconnecting: shell, tmux[:name] or screen[:name]
+
+	ControlMaster  bool   `yaml:"control_master,omitempty"`  // Share one connection between invocations through ~/.sshclient/sockets
+	ControlPersist string `yaml:"control_persist,omitempty"` // Idle time before the master exits: seconds, a duration like 30m, yes or no (default 10m)
+
+	SendEnv       []string `yaml:"send_env,omitempty"`       // Local environment variables sent to the server (wildcards allowed)
+	SetEnv        []string `yaml:"set_env,omitempty"`        // NAME=value pairs sent to the server
+	RequestTTY    string   `yaml:"request_tty,omitempty"`    // Pseudo terminal for commands: auto (default), yes, force or no
+	RemoteCommand string   `yaml:"remote_command,omitempty"` // Command run when none is given on the command line
+
+	Ciphers           string `yaml:"ciphers,omitempty"`             // Comma separated ciphers, in order of preference (+, - or ^ modify the defaults)
+	KexAlgorithms     string `yaml:"kex_algorithms,omitempty"`      // Comma separated key exchange algorithms
+	MACs              string `yaml:"macs,omitempty"`                // Comma separated MAC algorithms
+	HostKeyAlgorithms string `yaml:"host_key_algorithms,omitempty"` // Comma separated host key algorithms
+	Compression       bool   `yaml:"compression,omitempty"`         // Not supported by this client, a warning is shown
+
+	IgnoredDirectives []string `yaml:"-"` // ~/.ssh/config directives that have no effect
+	FromSSHConfig     bool     `yaml:"-"` // Resolved from ~/.ssh/config rather than a custom profile
+
+	EncryptedPassphrase    string   `yaml:"encrypted_passphrase,omitempty"`     // Encrypted key passphrase (AES-256-GCM)
+	EncryptedSocksPassword string   `yaml:"encrypted_socks_password,omitempty"` // Encrypted SOCKS5 proxy password (AES-256-GCM)
+	AuthOrder              []string `yaml:"auth_order,omitempty"`               // Auth methods in order: agent, default-keys, key, password, keyboard-interactive
+}
+
+// GetPassword returns the profile password, decrypting it if necessary
+func (p *Profile) GetPassword() (string, error) {
+	if p.EncryptedPassword != "" {
+		return DecryptAuto(p.EncryptedPassword)
+	}
+	// Legacy plain text password
+	return p.Password, nil
+}
+
+// GetPassphrase returns the decrypted private key passphrase, if one is stored
+func (p *Profile) GetPassphrase() (string, error) {
+	return DecryptAuto(p.EncryptedPassphrase)
+}
+
+// GetSocksPassword returns the password required by the local SOCKS5 proxy,
+// decrypting it if necessary
+func (p *Profile) GetSocksPassword() (string, error) {
+	if p.EncryptedSocksPassword != "" {
+		return DecryptAuto(p.EncryptedSocksPassword)
+	}
+	// Legacy plain text password
+	return p.SocksPassword, nil
 }
 
 // ProfileConfig represents the configuration file structure
@@ -61,6 +137,11 @@ func LoadProfiles() (*ProfileConfig, error) {
 		return nil, fmt.Errorf("failed to read config: %w", err)
 	}
 
+	return parseProfiles(data)
+}
+
+// parseProfiles parses the contents of config.yaml
+func parseProfiles(data []byte) (*ProfileConfig, error) {
 	var config ProfileConfig
 	if err := yaml.Unmarshal(data, &config); err != nil {
 		return nil, fmt.Errorf("failed to parse config: %w", err)
@@ -73,29 +154,30 @@ func LoadProfiles() (*ProfileConfig, error) {
 	return &config, nil
 }
 
-// SaveProfiles saves profiles to the YAML config file
-func SaveProfiles(config *ProfileConfig) error {
+// UpdateProfiles loads the profiles, applies update and saves the result
+// while holding the config file's lock, so concurrent updates are not lost
+func UpdateProfiles(update func(config *ProfileConfig) error) error {
 	configPath, err := GetConfigPath()
 	if err != nil {
 		return err
 	}
 
-	// Ensure config directory exists
-	configDir := filepath.Dir(configPath)
-	if err := os.MkdirAll(configDir, 0700); err != nil {
-		return fmt.Errorf("failed to create config directory: %w", err)
-	}
-
-	data, err := yaml.Marshal(config)
-	if err != nil {
-		return fmt.Errorf("failed to marshal config: %w", err)
-	}
+	return updateFileAtomic(configPath, 0600, func(data []byte) ([]byte, error) {
+		config, err := parseProfiles(data)
+		if err != nil {
+			return nil, err
+		}
 
-	if err := os.WriteFile(configPath, data, 0600); err != nil {
-		return fmt.Errorf("failed to write config: %w", err)
-	}
+		if err := update(config); err != nil {
+			return nil, err
+		}
 
-	return nil
+		data, err = yaml.Marshal(config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal config: %w", err)
+		}
+		return data, nil
+	})
 }
 
 // GetProfile retrieves a profile by name
@@ -116,28 +198,37 @@ func GetProfile(name string) (*Profile, error) {
 
 // AddProfile adds a new profile
 func AddProfile(profile Profile) error {
-	config, err := LoadProfiles()
-	if err != nil {
-		return err
-	}
+	return UpdateProfiles(func(config *ProfileConfig) error {
+		config.Profiles[profile.Name] = profile
+		return nil
+	})
+}
 
-	config.Profiles[profile.Name] = profile
-	return SaveProfiles(config)
+// UpdateProfile applies update to the stored profile while holding the
+// config file's lock, so only the fields update sets are changed
+func UpdateProfile(name string, update func(profile *Profile)) error {
+	return UpdateProfiles(func(config *ProfileConfig) error {
+		profile, ok := config.Profiles[name]
+		if !ok {
+			return fmt.Errorf("profile '%s' not found", name)
+		}
+
+		update(&profile)
+		config.Profiles[name] = profile
+		return nil
+	})
 }
 
 // RemoveProfile removes a profile by name
 func RemoveProfile(name string) error {
-	config, err := LoadProfiles()
-	if err != nil {
-		return err
-	}
-
-	if _, ok := config.Profiles[name]; !ok {
-		return fmt.Errorf("profile '%s' not found", name)
-	}
+	return UpdateProfiles(func(config *ProfileConfig) error {
+		if _, ok := config.Profiles[name]; !ok {
+			return fmt.Errorf("profile '%s' not found", name)
+		}
 
-	delete(config.Profiles, name)
-	return SaveProfiles(config)
+		delete(config.Profiles, name)
+		return nil
+	})
 }
 
 // ListProfiles returns all profile names
@@ -155,111 +246,6 @@ func ListProfiles() ([]string, error) {
 	return names, nil
 }
 
-// ParseSSHConfig parses ~/.ssh/config file and returns profiles
-func ParseSSHConfig() (map[string]Profile, error) {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return nil, err
-	}
-
-	configPath := filepath.Join(home, ".ssh", "config")
-	if _, err := os.Stat(configPath); os.IsNotExist(err) {
-		return make(map[string]Profile), nil
-	}
-
-	file, err := os.Open(configPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open SSH config: %w", err)
-	}
-	defer file.Close()
-
-	profiles := make(map[string]Profile)
-	var currentHost string
-	var currentProfile Profile
-
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-
-		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-
-		// Parse key-value pairs
-		parts := strings.Fields(line)
-		if len(parts) < 2 {
-			continue
-		}
-
-		key := strings.ToLower(parts[0])
-		value := strings.Join(parts[1:], " ")
-
-		switch key {
-		case "host":
-			// Save previous host if exists
-			if currentHost != "" {
-				currentProfile.Name = currentHost
-				profiles[currentHost] = currentProfile
-			}
-
-			// Start new host
-			currentHost = value
-			currentProfile = Profile{
-				Port: "22", // Default port
-			}
-
-		case "hostname":
-			currentProfile.Host = value
-
-		case "user":
-			currentProfile.User = value
-
-		case "port":
-			currentProfile.Port = value
-
-		case "identityfile":
-			// Expand ~ to home directory
-			if strings.HasPrefix(value, "~") {
-				value = filepath.Join(home, value[1:])
-			}
-			currentProfile.Key = value
-		}
-	}
-
-	// Save last host
-	if currentHost != "" {
-		currentProfile.Name = currentHost
-		profiles[currentHost] = currentProfile
-	}
-
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading SSH config: %w", err)
-	}
-
-	return profiles, nil
-}
-
-// GetProfileFromSSHConfig retrieves a profile from SSH config
-func GetProfileFromSSHConfig(name string) (*Profile, error) {
-	profiles, err := ParseSSHConfig()
-	if err != nil {
-		return nil, err
-	}
-
-	profile, ok := profiles[name]
-	if !ok {
-		return nil, fmt.Errorf("host '%s' not found in SSH config", name)
-	}
-
-	// If hostname is not set, use the host name
-	if profile.Host == "" {
-		profile.Host = name
-	}
-
-	return &profile, nil
-}
-
 // FindProfile searches for a profile in both sources
 // Priority: 1. Custom profiles, 2. SSH config
 func FindProfile(name string) (*Profile, error) {
@@ -274,6 +260,10 @@ func FindProfile(name string) (*Profile, error) {
 	if err == nil {
 		return profile, nil
 	}
+	if !errors.Is(err, errNotInSSHConfig) {
+		// A broken ~/.ssh/config should not look like a missing profile
+		return nil, err
+	}
 
 	return nil, fmt.Errorf("profile '%s' not found in custom profiles or SSH config", name)
 }
@@ -0,0 +1,44 @@
+package main
+
+import "testing"
+
+func TestUpdateProfileKeepsConcurrentChanges(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	if err := AddProfile(Profile{Name: "web", Host: "web.example.com", User: "deploy", Port: "22"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Loaded before a prompt, then changed by another process meanwhile
+	stale, err := GetProfile("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := *stale
+	changed.Port = "2222"
+	if err := AddProfile(changed); err != nil {
+		t.Fatal(err)
+	}
+
+	err = UpdateProfile("web", func(profile *Profile) {
+		profile.EncryptedPassphrase = "encrypted"
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := GetProfile("web")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved.Port != "2222" {
+		t.Errorf("port = %s, want the concurrent change 2222", saved.Port)
+	}
+	if saved.EncryptedPassphrase != "encrypted" {
+		t.Errorf("passphrase was not saved")
+	}
+
+	if err := UpdateProfile("missing", func(*Profile) {}); err == nil {
+		t.Error("UpdateProfile created a missing profile")
+	}
+}
@@ -0,0 +1,564 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Global requests understood by a control master, besides keepalives
+const (
+	controlCheckRequest = "check@sshclient" // Reply carries the master's pid
+	controlStopRequest  = "stop@sshclient"  // Close the connection and exit
+)
+
+// controlMasterEnv is set in the environment of the background process
+// started as control master; its value is the socket to listen on
+const controlMasterEnv = "SSHCLIENT_CONTROL_MASTER"
+
+// DefaultControlPersist is how long an idle control master stays up
+const DefaultControlPersist = 10 * time.Minute
+
+// ControlPersistNo keeps the control master up only while the connection
+// that started it, and any sharing it, are open
+const ControlPersistNo time.Duration = -1
+
+// ParseControlPersist parses a control_persist value: a number of seconds
+// or a duration such as "30m"; "yes" and "0" keep the master up until
+// it is stopped (returned as 0), "no" returns ControlPersistNo and empty
+// means DefaultControlPersist
+func ParseControlPersist(value string) (time.Duration, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	switch value {
+	case "":
+		return DefaultControlPersist, nil
+	case "yes", "0":
+		return 0, nil
+	case "no":
+		return ControlPersistNo, nil
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return d, nil
+	}
+	return 0, fmt.Errorf("invalid ControlPersist value %q (use yes, no, seconds or a duration like 30m)", value)
+}
+
+// ControlSocketPath returns the control socket for user@host:port
+// The name is a hash, like OpenSSH's %C, so it fits the socket path limit.
+func ControlSocketPath(user, host, port string) (string, error) {
+	configDir, err := GetConfigDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(user + "@" + net.JoinHostPort(host, port)))
+	return filepath.Join(configDir, "sockets", hex.EncodeToString(sum[:8])+".sock"), nil
+}
+
+// SetControlPath makes Connect use the control master listening on path,
+// if one is running, instead of dialing the server
+func (c *SSHClient) SetControlPath(path string) {
+	c.controlPath = path
+}
+
+// dialControl connects to the control master on the socket
+func dialControl(path string, user string) (*ssh.Client, error) {
+	conn, err := net.DialTimeout("unix", path, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &ssh.ClientConfig{
+		User: user,
+		// Only the user can reach the socket and the master's host key is
+		// generated for each run, so there is nothing to verify
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	}
+	sshConn, chans, reqs, err := ssh.NewClientConn(conn, "control", config)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("control master handshake failed: %w", err)
+	}
+	return ssh.NewClient(sshConn, chans, reqs), nil
+}
+
+// connectControl connects through the control master
+func (c *SSHClient) connectControl() error {
+	client, err := dialControl(c.controlPath, c.config.User)
+	if err != nil {
+		return err
+	}
+
+	c.setClient(client)
+	c.authMethod = "control master " + c.controlPath
+	return nil
+}
+
+// ControlMasterPid returns the pid of the control master listening on the
+// socket, or an error if none is running
+func ControlMasterPid(path string) (int, error) {
+	client, err := dialControl(path, localUserName())
+	if err != nil {
+		return 0, fmt.Errorf("no control master running: %w", err)
+	}
+	defer client.Close()
+
+	ok, reply, err := client.SendRequest(controlCheckRequest, true, nil)
+	if err != nil || !ok {
+		return 0, fmt.Errorf("control master on %s did not answer", path)
+	}
+	var pid struct{ Pid uint32 }
+	if err := ssh.Unmarshal(reply, &pid); err != nil {
+		return 0, fmt.Errorf("invalid reply from control master: %w", err)
+	}
+	return int(pid.Pid), nil
+}
+
+// StartControlMaster runs this command again in the background as control
+// master for the socket and waits until it is connected and listening
+// The master shares the terminal until then, so it can ask for passwords,
+// passphrases or host key confirmation.
+func StartControlMaster(path string, args []string) error {
+	// The master gets its readiness pipe as an extra file descriptor
+	if runtime.GOOS == "windows" {
+		return fmt.Errorf("connection sharing is not supported on Windows")
+	}
+
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	ready, readyWriter, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+
+	cmd := exec.Command(executable, args...)
+	cmd.Env = append(os.Environ(), controlMasterEnv+"="+path)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = []*os.File{readyWriter} // fd 3 in the master
+	cmd.SysProcAttr = controlMasterSysProcAttr()
+
+	if err := cmd.Start(); err != nil {
+		readyWriter.Close()
+		return fmt.Errorf("failed to start control master: %w", err)
+	}
+	readyWriter.Close()
+	go cmd.Wait()
+
+	// The master writes "ok" once listening, or closes the pipe when it fails
+	line, _ := bufio.NewReader(ready).ReadString('\n')
+	if strings.TrimSpace(line) != "ok" {
+		return fmt.Errorf("control master failed to start")
+	}
+	return nil
+}
+
+// IsControlMaster reports whether this process was started as control
+// master and returns the socket it should listen on
+func IsControlMaster() (string, bool) {
+	path := os.Getenv(controlMasterEnv)
+	return path, path != ""
+}
+
+// controlMaster shares one SSH connection with the sshclient processes
+// connecting to its socket
+type controlMaster struct {
+	client   *SSHClient
+	listener net.Listener
+	config   *ssh.ServerConfig
+	persist  time.Duration // Idle time before exiting, 0 for no limit
+
+	mu      sync.Mutex
+	clients int         // Connected sshclient processes
+	served  bool        // Whether any process has connected yet
+	idle    *time.Timer // Running while no process is connected
+	done    chan struct{}
+	once    sync.Once
+}
+
+// ServeControl listens on the control socket and serves other sshclient
+// processes until the connection drops, the master is stopped or it has
+// been idle for persist (0 for no limit, ControlPersistNo to exit once the
+// last process disconnects)
+// The process that started the master is notified through fd 3 once the
+// socket is listening, and the terminal is released.
+func (c *SSHClient) ServeControl(path string, persist time.Duration) error {
+	listener, err := listenControl(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+	signer, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	m := &controlMaster{
+		client:   c,
+		listener: listener,
+		config:   config,
+		persist:  persist,
+		done:     make(chan struct{}),
+	}
+	m.updateClients(0)
+
+	go func() {
+		c.Wait()
+		m.stop()
+	}()
+	go m.serve()
+
+	ready := os.NewFile(3, "ready")
+	fmt.Fprintln(ready, "ok")
+	ready.Close()
+	detachStdio()
+
+	<-m.done
+	c.Close()
+	return nil
+}
+
+// listenControl listens on the socket, replacing a stale socket file left
+// behind by a master that did not exit cleanly
+func listenControl(path string) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create sockets directory: %w", err)
+	}
+
+	if _, err := os.Stat(path); err == nil {
+		if _, err := ControlMasterPid(path); err == nil {
+			return nil, fmt.Errorf("a control master is already running on %s", path)
+		}
+		os.Remove(path)
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", path, err)
+	}
+	if err := os.Chmod(path, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+// serve accepts connections until the listener is closed
+func (m *controlMaster) serve() {
+	for {
+		conn, err := m.listener.Accept()
+		if err != nil {
+			return
+		}
+		go m.serveConn(conn)
+	}
+}
+
+// stop closes the listener and ends ServeControl
+func (m *controlMaster) stop() {
+	m.once.Do(func() {
+		m.listener.Close()
+		close(m.done)
+	})
+}
+
+// updateClients adjusts the number of connected processes and runs the
+// idle timer while there are none
+func (m *controlMaster) updateClients(delta int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.clients += delta
+	if delta > 0 {
+		m.served = true
+	}
+	if m.idle != nil {
+		m.idle.Stop()
+		m.idle = nil
+	}
+	if m.clients > 0 {
+		return
+	}
+
+	switch {
+	case m.persist == ControlPersistNo && m.served:
+		m.stop()
+	case m.persist == ControlPersistNo:
+		// The process that started the master connects right away, do not
+		// wait forever if it never does
+		m.idle = time.AfterFunc(DefaultControlPersist, m.stop)
+	case m.persist > 0:
+		m.idle = time.AfterFunc(m.persist, m.stop)
+	}
+}
+
+// serveConn serves one sshclient process: its channels are opened on the
+// shared connection and proxied
+func (m *controlMaster) serveConn(conn net.Conn) {
+	sshConn, chans, reqs, err := ssh.NewServerConn(conn, m.config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sshConn.Close()
+
+	m.updateClients(1)
+	defer m.updateClients(-1)
+
+	go m.handleRequests(reqs)
+	for newChannel := range chans {
+		go m.client.proxyChannel(newChannel)
+	}
+}
+
+// handleRequests answers the global requests of a connected process
+// Remote forwarding is not multiplexed, such requests are refused.
+func (m *controlMaster) handleRequests(reqs <-chan *ssh.Request) {
+	for req := range reqs {
+		switch req.Type {
+		case keepAliveRequest:
+			// Keepalives check the shared connection
+			alive := m.client.alive()
+			req.Reply(alive, nil)
+		case controlCheckRequest:
+			req.Reply(true, ssh.Marshal(struct{ Pid uint32 }{uint32(os.Getpid())}))
+		case controlStopRequest:
+			req.Reply(true, nil)
+			m.stop()
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+// proxyChannel opens the same channel on the connection and copies data
+// and requests in both directions until both sides are closed
+func (c *SSHClient) proxyChannel(newChannel ssh.NewChannel) {
+	client := c.currentClient()
+	if client == nil {
+		newChannel.Reject(ssh.ConnectionFailed, "not connected")
+		return
+	}
+
+	upstream, upstreamReqs, err := client.OpenChannel(newChannel.ChannelType(), newChannel.ExtraData())
+	if err != nil {
+		var openErr *ssh.OpenChannelError
+		if errors.As(err, &openErr) {
+			newChannel.Reject(openErr.Reason, openErr.Message)
+		} else {
+			newChannel.Reject(ssh.ConnectionFailed, err.Error())
+		}
+		return
+	}
+
+	downstream, downstreamReqs, err := newChannel.Accept()
+	if err != nil {
+		upstream.Close()
+		return
+	}
+
+	// Output (stdout and stderr) goes to the process, input to the server
+	var output sync.WaitGroup
+	output.Add(2)
+	go func() {
+		defer output.Done()
+		io.Copy(downstream, upstream)
+	}()
+	go func() {
+		defer output.Done()
+		io.Copy(downstream.Stderr(), upstream.Stderr())
+	}()
+	go func() {
+		io.Copy(upstream, downstream)
+		upstream.CloseWrite()
+	}()
+
+	go func() {
+		proxyChannelRequests(downstreamReqs, upstream)
+		upstream.Close()
+	}()
+
+	// Requests such as exit-status arrive before the server closes the channel
+	proxyChannelRequests(upstreamReqs, downstream)
+	output.Wait()
+	downstream.CloseWrite()
+	downstream.Close()
+}
+
+// proxyChannelRequests sends requests to the other channel and relays the replies
+func proxyChannelRequests(reqs <-chan *ssh.Request, channel ssh.Channel) {
+	for req := range reqs {
+		ok, err := channel.SendRequest(req.Type, req.WantReply, req.Payload)
+		if err != nil {
+			ok = false
+		}
+		req.Reply(ok, nil)
+	}
+}
+
+// parseControlTarget parses a control command target, @profile or
+// user@host[:port], into the values the socket name is derived from
+func parseControlTarget(arg string) (user, host, port string, err error) {
+	if strings.HasPrefix(arg, "@") {
+		profile, err := FindProfile(strings.TrimPrefix(arg, "@"))
+		if err != nil {
+			return "", "", "", err
+		}
+		return profile.User, profile.Host, cmp.Or(profile.Port, "22"), nil
+	}
+
+	user, hostPort, ok := parseUserHost(arg)
+	if !ok {
+		return "", "", "", fmt.Errorf("invalid target %q (use @profile or user@host[:port])", arg)
+	}
+	host, port = hostPort, "22"
+	if h, p, err := net.SplitHostPort(hostPort); err == nil {
+		host, port = h, p
+	}
+	return user, host, port, nil
+}
+
+// ControlCheck reports whether a control master is running for the target
+func ControlCheck(target string) error {
+	user, host, port, err := parseControlTarget(target)
+	if err != nil {
+		return err
+	}
+	path, err := ControlSocketPath(user, host, port)
+	if err != nil {
+		return err
+	}
+
+	pid, err := ControlMasterPid(path)
+	if err != nil {
+		return fmt.Errorf("no control master running for %s@%s", user, net.JoinHostPort(host, port))
+	}
+	fmt.Printf("✅ Master running (pid %d) for %s@%s\n", pid, user, net.JoinHostPort(host, port))
+	fmt.Printf("   Socket: %s\n", path)
+	return nil
+}
+
+// ControlStop asks the control master for the target to close its
+// connection and exit
+func ControlStop(target string) error {
+	user, host, port, err := parseControlTarget(target)
+	if err != nil {
+		return err
+	}
+	path, err := ControlSocketPath(user, host, port)
+	if err != nil {
+		return err
+	}
+
+	client, err := dialControl(path, user)
+	if err != nil {
+		return fmt.Errorf("no control master running for %s@%s", user, net.JoinHostPort(host, port))
+	}
+	defer client.Close()
+
+	if ok, _, err := client.SendRequest(controlStopRequest, true, nil); err != nil || !ok {
+		return fmt.Errorf("control master did not accept the stop request")
+	}
+	fmt.Printf("✅ Stopped control master for %s@%s\n", user, net.JoinHostPort(host, port))
+	return nil
+}
+
+// PrintControlHelp prints control command usage help
+func PrintControlHelp() {
+	fmt.Println("Connection Sharing - Manage control masters")
+	fmt.Println()
+	fmt.Println("Usage:")
+	fmt.Println("  sshclient control <command> <target>")
+	fmt.Println()
+	fmt.Println("Available Commands:")
+	fmt.Println("  check <target>   Check whether a master is running")
+	fmt.Println("  stop <target>    Close the master's connection and exit")
+	fmt.Println()
+	fmt.Println("Targets:")
+	fmt.Println("  @profile, user@host or user@host:port")
+	fmt.Println()
+	fmt.Println("Starting a Master:")
+	fmt.Println("  Set control_master: true in a profile (or -o ControlMaster=auto).")
+	fmt.Println("  The first connection starts a master in the background, later ones")
+	fmt.Println("  open their sessions through it. An idle master exits after")
+	fmt.Println("  control_persist (default 10m, \"yes\" to keep it until stopped, \"no\"")
+	fmt.Println("  to exit as soon as the connection that started it closes).")
+	fmt.Println()
+	fmt.Println("Examples:")
+	fmt.Println("  sshclient control check @myserver")
+	fmt.Println("  sshclient control stop @myserver")
+	fmt.Println()
+	fmt.Println("Sockets:")
+	fmt.Println("  ~/.sshclient/sockets/")
+}
+
+// HandleControlCommand handles control master commands
+func HandleControlCommand(args []string) error {
+	if len(args) < 1 {
+		PrintControlHelp()
+		return nil
+	}
+
+	subcommand := args[0]
+	switch subcommand {
+	case "check", "stop":
+		if len(args) < 2 {
+			fmt.Printf("Error: control %s requires a target\n", subcommand)
+			fmt.Println()
+			PrintControlHelp()
+			return fmt.Errorf("missing target")
+		}
+	}
+
+	switch subcommand {
+	case "check":
+		return ControlCheck(args[1])
+
+	case "stop":
+		return ControlStop(args[1])
+
+	case "help", "-h", "--help":
+		PrintControlHelp()
+		return nil
+
+	default:
+		fmt.Printf("Unknown control command: %s\n\n", subcommand)
+		PrintControlHelp()
+		return fmt.Errorf("unknown command: %s", subcommand)
+	}
+}
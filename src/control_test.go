@@ -0,0 +1,101 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseControlPersist(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "", want: DefaultControlPersist},
+		{value: "yes", want: 0},
+		{value: "0", want: 0},
+		{value: "No", want: ControlPersistNo},
+		{value: "600", want: 10 * time.Minute},
+		{value: "30m", want: 30 * time.Minute},
+		{value: "1h30m", want: 90 * time.Minute},
+		{value: "-5", wantErr: true},
+		{value: "forever", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseControlPersist(tt.value)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseControlPersist(%q) = %v, want error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseControlPersist(%q) error: %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseControlPersist(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
+
+// testControlMaster returns a master that is not connected to anything,
+// for checking when it stops
+func testControlMaster(t *testing.T, persist time.Duration) *controlMaster {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &controlMaster{listener: listener, persist: persist, done: make(chan struct{})}
+	t.Cleanup(m.stop)
+	m.updateClients(0)
+	return m
+}
+
+func stopped(m *controlMaster) bool {
+	select {
+	case <-m.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestControlPersistNo(t *testing.T) {
+	m := testControlMaster(t, ControlPersistNo)
+	if stopped(m) {
+		t.Fatal("master stopped before its first client connected")
+	}
+
+	m.updateClients(1) // The process that started the master
+	m.updateClients(1) // A second process sharing the connection
+	m.updateClients(-1)
+	if stopped(m) {
+		t.Fatal("master stopped while a client was still connected")
+	}
+
+	m.updateClients(-1)
+	if !stopped(m) {
+		t.Error("master kept running after its last client disconnected")
+	}
+}
+
+func TestControlPersistIdleTimer(t *testing.T) {
+	m := testControlMaster(t, 10*time.Millisecond)
+	m.updateClients(1)
+	time.Sleep(50 * time.Millisecond)
+	if stopped(m) {
+		t.Fatal("master stopped while a client was connected")
+	}
+
+	m.updateClients(-1)
+	select {
+	case <-m.done:
+	case <-time.After(5 * time.Second):
+		t.Error("idle master did not stop after ControlPersist")
+	}
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// controlMasterSysProcAttr starts the control master in its own session so
+// it outlives the terminal it was started from
+func controlMasterSysProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
+
+// detachStdio points stdin, stdout and stderr at /dev/null once the control
+// master no longer needs the terminal
+func detachStdio() {
+	devNull, err := os.OpenFile(os.DevNull, os.O_RDWR, 0)
+	if err != nil {
+		return
+	}
+	defer devNull.Close()
+
+	for _, fd := range []int{0, 1, 2} {
+		syscall.Dup2(int(devNull.Fd()), fd)
+	}
+}
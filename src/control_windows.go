@@ -0,0 +1,16 @@
+//go:build windows
+
+package main
+
+import (
+	"syscall"
+)
+
+// controlMasterSysProcAttr is unused on Windows, where StartControlMaster
+// is not supported
+func controlMasterSysProcAttr() *syscall.SysProcAttr {
+	return nil
+}
+
+// detachStdio is a no-op on Windows
+func detachStdio() {}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// DefaultConnectTimeout is how long to wait for the TCP connection when no
+// connect timeout is configured
+const DefaultConnectTimeout = 10 * time.Second
+
+// fallbackDelay is how long the first address family gets before the other
+// one is tried in parallel (Happy Eyeballs, RFC 6555)
+const fallbackDelay = 300 * time.Millisecond
+
+// Address families, as in OpenSSH's AddressFamily
+const (
+	AddressFamilyAny   = "any"   // IPv4 and IPv6 (default)
+	AddressFamilyInet  = "inet"  // IPv4 only
+	AddressFamilyInet6 = "inet6" // IPv6 only
+)
+
+// DialOptions controls how the TCP connection to the server is made
+// It has no effect when connecting through a jump host or a proxy command.
+type DialOptions struct {
+	AddressFamily string // any (default), inet or inet6
+	BindAddress   string // Local address to connect from
+	BindInterface string // Local interface whose address is used to connect from
+}
+
+// ParseAddressFamily normalizes an AddressFamily value; -4 and -6 style
+// values (4, 6, ipv4, ipv6) are accepted as well
+func ParseAddressFamily(value string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "", AddressFamilyAny:
+		return AddressFamilyAny, nil
+	case AddressFamilyInet, "4", "ipv4":
+		return AddressFamilyInet, nil
+	case AddressFamilyInet6, "6", "ipv6":
+		return AddressFamilyInet6, nil
+	}
+	return "", fmt.Errorf("invalid address family %q (use any, inet or inet6)", value)
+}
+
+// SetDialOptions sets the address family and local address used to connect
+// A bind address or interface of one family also restricts the connection
+// to that family.
+func (c *SSHClient) SetDialOptions(opts DialOptions) error {
+	family, err := ParseAddressFamily(opts.AddressFamily)
+	if err != nil {
+		return err
+	}
+
+	var local net.IP
+	switch {
+	case opts.BindAddress != "" && opts.BindInterface != "":
+		return fmt.Errorf("bind address and bind interface cannot be used together")
+	case opts.BindAddress != "":
+		local = net.ParseIP(opts.BindAddress)
+		if local == nil {
+			return fmt.Errorf("invalid bind address %q", opts.BindAddress)
+		}
+	case opts.BindInterface != "":
+		local, err = interfaceAddress(opts.BindInterface, family)
+		if err != nil {
+			return err
+		}
+	}
+
+	network := "tcp"
+	switch family {
+	case AddressFamilyInet:
+		network = "tcp4"
+	case AddressFamilyInet6:
+		network = "tcp6"
+	}
+	if local != nil {
+		localFamily := "tcp6"
+		if local.To4() != nil {
+			localFamily = "tcp4"
+		}
+		if network != "tcp" && network != localFamily {
+			return fmt.Errorf("bind address %s does not match address family %s", local, family)
+		}
+		network = localFamily
+		c.localAddr = &net.TCPAddr{IP: local}
+	} else {
+		c.localAddr = nil
+	}
+
+	c.network = network
+	return nil
+}
+
+// interfaceAddress returns the address of a local interface to bind to,
+// preferring IPv4 unless the family requires IPv6
+// Link-local IPv6 addresses are skipped as they cannot reach other networks.
+func interfaceAddress(name, family string) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find interface %s: %w", name, err)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get addresses of interface %s: %w", name, err)
+	}
+
+	var ipv6 net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ipNet.IP.To4() != nil {
+			if family != AddressFamilyInet6 {
+				return ipNet.IP, nil
+			}
+		} else if ipv6 == nil {
+			ipv6 = ipNet.IP
+		}
+	}
+
+	if ipv6 != nil && family != AddressFamilyInet {
+		return ipv6, nil
+	}
+	return nil, fmt.Errorf("interface %s has no usable %s address", name, familyName(family))
+}
+
+// familyName describes an address family for error messages
+func familyName(family string) string {
+	switch family {
+	case AddressFamilyInet:
+		return "IPv4"
+	case AddressFamilyInet6:
+		return "IPv6"
+	}
+	return "IP"
+}
+
+// dialTCP connects to addr directly
+// When the host resolves to several addresses they are tried in turn within
+// the connect timeout, and IPv4 is tried in parallel if IPv6 does not answer
+// quickly (or the other way round, depending on the first address).
+func (c *SSHClient) dialTCP(addr string) (net.Conn, error) {
+	dialer := net.Dialer{
+		Timeout:       c.config.Timeout,
+		FallbackDelay: fallbackDelay,
+	}
+	if c.localAddr != nil {
+		dialer.LocalAddr = c.localAddr
+	}
+
+	network := c.network
+	if network == "" {
+		network = "tcp"
+	}
+	return dialer.Dial(network, addr)
+}
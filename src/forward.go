@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+)
+
+// LocalForward describes a local port forward (-L)
+// Connections accepted on BindAddress are tunneled to Target through the SSH server
+type LocalForward struct {
+	BindAddress string // Local listen address (host:port)
+	Target      string // Remote target address as seen from the server (host:port)
+}
+
+// String returns a readable description of the forward
+func (f LocalForward) String() string {
+	return f.BindAddress + " -> " + f.Target
+}
+
+// ParseLocalForward parses a -L specification in the form
+// [bind_address:]port:host:hostport
+func ParseLocalForward(spec string) (LocalForward, error) {
+	bind, port, target, err := parseForwardSpec(spec)
+	if err != nil {
+		return LocalForward{}, fmt.Errorf("invalid local forward: %w", err)
+	}
+
+	// "*" and an empty bind address mean all interfaces
+	if bind == "*" {
+		bind = ""
+	}
+
+	return LocalForward{
+		BindAddress: net.JoinHostPort(bind, port),
+		Target:      target,
+	}, nil
+}
+
+// RemoteForward describes a remote port forward (-R)
+// Connections accepted by the server on BindAddress are tunneled back to Target
+type RemoteForward struct {
+	BindAddress string // Listen address on the server (host:port)
+	Target      string // Local target address (host:port)
+}
+
+// ParseRemoteForward parses a -R specification in the form
+// [bind_address:]port:host:hostport
+// Unlike OpenSSH, "*" and an empty bind address listen on IPv4 only: the
+// ssh package cannot route connections for the empty address, which means
+// all address families. Use [::] for IPv6.
+func ParseRemoteForward(spec string) (RemoteForward, error) {
+	bind, port, target, err := parseForwardSpec(spec)
+	if err != nil {
+		return RemoteForward{}, fmt.Errorf("invalid remote forward: %w", err)
+	}
+
+	// The server needs a concrete IP; "*" and "" ask it to listen on all
+	// IPv4 interfaces
+	if bind == "*" || bind == "" {
+		bind = "0.0.0.0"
+	}
+
+	return RemoteForward{
+		BindAddress: net.JoinHostPort(bind, port),
+		Target:      target,
+	}, nil
+}
+
+// parseForwardSpec parses [bind_address:]port:host:hostport into its parts.
+// The bind address defaults to localhost when omitted.
+func parseForwardSpec(spec string) (bind, port, target string, err error) {
+	parts, err := splitForwardSpec(spec)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	var host, hostPort string
+	switch len(parts) {
+	case 3:
+		bind, port, host, hostPort = "localhost", parts[0], parts[1], parts[2]
+	case 4:
+		bind, port, host, hostPort = parts[0], parts[1], parts[2], parts[3]
+	default:
+		return "", "", "", fmt.Errorf("%q: expected [bind_address:]port:host:hostport", spec)
+	}
+
+	if port == "" || host == "" || hostPort == "" {
+		return "", "", "", fmt.Errorf("%q: empty field", spec)
+	}
+
+	return bind, port, net.JoinHostPort(host, hostPort), nil
+}
+
+// splitForwardSpec splits a forward specification on ':' while keeping
+// bracketed IPv6 addresses ([::1]) together
+func splitForwardSpec(spec string) ([]string, error) {
+	var parts []string
+	var current strings.Builder
+	inBrackets := false
+
+	for _, r := range spec {
+		switch {
+		case r == '[' && !inBrackets:
+			inBrackets = true
+		case r == ']' && inBrackets:
+			inBrackets = false
+		case r == ':' && !inBrackets:
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteRune(r)
+		}
+	}
+
+	if inBrackets {
+		return nil, fmt.Errorf("%q: unterminated '['", spec)
+	}
+
+	return append(parts, current.String()), nil
+}
+
+// StartLocalForward listens on the local address and tunnels every
+// accepted connection to the target through the SSH connection
+func (c *SSHClient) StartLocalForward(fwd LocalForward) error {
+	if c.client == nil {
+		return fmt.Errorf("not connected")
+	}
+
+	listener, err := net.Listen("tcp", fwd.BindAddress)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", fwd.BindAddress, err)
+	}
+	c.listeners = append(c.listeners, listener)
+
+	go c.serveLocalForward(listener, fwd)
+	return nil
+}
+
+// serveLocalForward accepts local connections until the listener is closed
+func (c *SSHClient) serveLocalForward(listener net.Listener, fwd LocalForward) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			client := c.currentClient()
+			if client == nil {
+				fmt.Fprintf(os.Stderr, "Local forward %s: not connected\n", fwd.BindAddress)
+				conn.Close()
+				return
+			}
+			remote, err := client.Dial("tcp", fwd.Target)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Local forward %s: failed to connect to %s: %v\n", fwd.BindAddress, fwd.Target, err)
+				conn.Close()
+				return
+			}
+			pipe(conn, remote)
+		}()
+	}
+}
+
+// StartRemoteForward asks the server to listen on the remote address and
+// tunnels every inbound connection to the local target
+func (c *SSHClient) StartRemoteForward(fwd RemoteForward) (net.Addr, error) {
+	if c.client == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	addr, err := c.listenRemoteForward(fwd)
+	if err != nil {
+		return nil, err
+	}
+	c.remoteForwards = append(c.remoteForwards, fwd)
+	return addr, nil
+}
+
+// listenRemoteForward sets up a remote forward on the current connection
+func (c *SSHClient) listenRemoteForward(fwd RemoteForward) (net.Addr, error) {
+	listener, err := c.client.Listen("tcp", fwd.BindAddress)
+	if err != nil {
+		if strings.Contains(err.Error(), "tcpip-forward request denied") {
+			return nil, fmt.Errorf("server refused remote port forwarding on %s (check AllowTcpForwarding and GatewayPorts in the server's sshd_config)", fwd.BindAddress)
+		}
+		return nil, fmt.Errorf("failed to listen on remote %s: %w", fwd.BindAddress, err)
+	}
+	c.listeners = append(c.listeners, listener)
+
+	go serveRemoteForward(listener, fwd)
+	return listener.Addr(), nil
+}
+
+// serveRemoteForward accepts forwarded channels until the listener is closed
+func serveRemoteForward(listener net.Listener, fwd RemoteForward) {
+	for {
+		remote, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			local, err := net.Dial("tcp", fwd.Target)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Remote forward %s: failed to connect to %s: %v\n", fwd.BindAddress, fwd.Target, err)
+				remote.Close()
+				return
+			}
+			pipe(remote, local)
+		}()
+	}
+}
+
+// pipe copies data in both directions until both sides are done,
+// then closes both connections
+func pipe(a, b net.Conn) {
+	done := make(chan struct{}, 2)
+
+	copyHalf := func(dst, src net.Conn) {
+		io.Copy(dst, src)
+		// Propagate EOF to the other side if it supports half-close
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+		done <- struct{}{}
+	}
+
+	go copyHalf(a, b)
+	go copyHalf(b, a)
+
+	<-done
+	<-done
+	a.Close()
+	b.Close()
+}
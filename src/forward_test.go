@@ -0,0 +1,113 @@
+package main
+
+import "testing"
+
+func TestParseLocalForward(t *testing.T) {
+	tests := []struct {
+		spec    string
+		bind    string
+		target  string
+		wantErr bool
+	}{
+		{spec: "8080:example.com:80", bind: "localhost:8080", target: "example.com:80"},
+		{spec: "127.0.0.1:8080:example.com:80", bind: "127.0.0.1:8080", target: "example.com:80"},
+		{spec: "*:8080:example.com:80", bind: ":8080", target: "example.com:80"},
+		{spec: ":8080:example.com:80", bind: ":8080", target: "example.com:80"},
+		{spec: "[::1]:8080:example.com:80", bind: "[::1]:8080", target: "example.com:80"},
+		{spec: "8080:[2001:db8::1]:80", bind: "localhost:8080", target: "[2001:db8::1]:80"},
+		{spec: "[::]:5432:[fd00::5]:5432", bind: "[::]:5432", target: "[fd00::5]:5432"},
+		{spec: "8080:example.com", wantErr: true},
+		{spec: "a:b:c:d:e", wantErr: true},
+		{spec: "8080::80", wantErr: true},
+		{spec: "[::1:8080:example.com:80", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		fwd, err := ParseLocalForward(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseLocalForward(%q) = %+v, want error", tt.spec, fwd)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseLocalForward(%q) error: %v", tt.spec, err)
+			continue
+		}
+		if fwd.BindAddress != tt.bind || fwd.Target != tt.target {
+			t.Errorf("ParseLocalForward(%q) = %s -> %s, want %s -> %s",
+				tt.spec, fwd.BindAddress, fwd.Target, tt.bind, tt.target)
+		}
+	}
+}
+
+func TestParseRemoteForward(t *testing.T) {
+	tests := []struct {
+		spec    string
+		bind    string
+		target  string
+		wantErr bool
+	}{
+		{spec: "8080:localhost:3000", bind: "localhost:8080", target: "localhost:3000"},
+		// All interfaces means IPv4 only, the ssh package cannot request ""
+		{spec: "*:8080:localhost:3000", bind: "0.0.0.0:8080", target: "localhost:3000"},
+		{spec: ":8080:localhost:3000", bind: "0.0.0.0:8080", target: "localhost:3000"},
+		{spec: "[::]:8080:localhost:3000", bind: "[::]:8080", target: "localhost:3000"},
+		{spec: "10.0.0.1:8080:localhost:3000", bind: "10.0.0.1:8080", target: "localhost:3000"},
+		{spec: "[::1]:8080:[::1]:3000", bind: "[::1]:8080", target: "[::1]:3000"},
+		{spec: "8080", wantErr: true},
+		{spec: ":8080:localhost:", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		fwd, err := ParseRemoteForward(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseRemoteForward(%q) = %+v, want error", tt.spec, fwd)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseRemoteForward(%q) error: %v", tt.spec, err)
+			continue
+		}
+		if fwd.BindAddress != tt.bind || fwd.Target != tt.target {
+			t.Errorf("ParseRemoteForward(%q) = %s -> %s, want %s -> %s",
+				tt.spec, fwd.BindAddress, fwd.Target, tt.bind, tt.target)
+		}
+	}
+}
+
+func TestParseDynamicForward(t *testing.T) {
+	tests := []struct {
+		spec    string
+		bind    string
+		wantErr bool
+	}{
+		{spec: "1080", bind: "localhost:1080"},
+		{spec: "0.0.0.0:1080", bind: "0.0.0.0:1080"},
+		{spec: "*:1080", bind: ":1080"},
+		{spec: "[::1]:1080", bind: "[::1]:1080"},
+		{spec: "", wantErr: true},
+		{spec: "localhost:", wantErr: true},
+		{spec: "a:b:1080", wantErr: true},
+		{spec: "[::1:1080", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		fwd, err := ParseDynamicForward(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseDynamicForward(%q) = %+v, want error", tt.spec, fwd)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseDynamicForward(%q) error: %v", tt.spec, err)
+			continue
+		}
+		if fwd.BindAddress != tt.bind {
+			t.Errorf("ParseDynamicForward(%q) = %s, want %s", tt.spec, fwd.BindAddress, tt.bind)
+		}
+	}
+}
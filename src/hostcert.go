@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ParseHostCAs parses host CA keys from a profile's host_ca list
+// Each entry is either a public key line ("ssh-ed25519 AAAA... comment")
+// or the path of a file containing one
+func ParseHostCAs(entries []string) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	for _, entry := range entries {
+		data := []byte(entry)
+		if _, _, _, _, err := ssh.ParseAuthorizedKey(data); err != nil {
+			data, err = os.ReadFile(expandHome(entry))
+			if err != nil {
+				return nil, fmt.Errorf("invalid host CA %q: not a public key and not readable: %w", entry, err)
+			}
+		}
+
+		key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid host CA %q: %w", entry, err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// isTrustedHostCA reports whether the CA key may sign certificates for the
+// host, either through a matching @cert-authority line or the profile's CAs
+func isTrustedHostCA(ca ssh.PublicKey, host, port string, lines []knownHostsLine, profileCAs []ssh.PublicKey) bool {
+	for _, key := range profileCAs {
+		if keysEqual(key, ca) {
+			return true
+		}
+	}
+
+	for _, line := range lines {
+		if line.Marker == markerCertAuthority && keysEqual(line.Key, ca) && line.matches(host, port) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isRevokedHostKey reports whether the key is listed as @revoked
+func isRevokedHostKey(key ssh.PublicKey, lines []knownHostsLine) bool {
+	for _, line := range lines {
+		if line.Marker == markerRevoked && keysEqual(line.Key, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyHostCertificate checks a host certificate signed by a trusted CA:
+// revocation, validity window, principals and signature
+func verifyHostCertificate(hostname string, remote net.Addr, cert *ssh.Certificate, lines []knownHostsLine) error {
+	host, _ := splitHostPort(hostname)
+
+	if isRevokedHostKey(cert.Key, lines) || isRevokedHostKey(cert.SignatureKey, lines) {
+		return fmt.Errorf("host certificate for %s rejected: key or CA is marked @revoked in known_hosts", host)
+	}
+
+	if cert.CertType != ssh.HostCert {
+		return fmt.Errorf("host certificate for %s rejected: not a host certificate", host)
+	}
+
+	now := uint64(time.Now().Unix())
+	if now < cert.ValidAfter {
+		return fmt.Errorf("host certificate for %s is not valid until %s", host, certTime(cert.ValidAfter))
+	}
+	if cert.ValidBefore != ssh.CertTimeInfinity && now >= cert.ValidBefore {
+		return fmt.Errorf("host certificate for %s expired at %s", host, certTime(cert.ValidBefore))
+	}
+
+	if len(cert.ValidPrincipals) > 0 && !slices.Contains(cert.ValidPrincipals, host) {
+		return fmt.Errorf("host certificate for %s is not valid for this host (principals: %s)",
+			host, strings.Join(cert.ValidPrincipals, ", "))
+	}
+
+	// Let the library verify the CA signature and anything not covered above
+	checker := &ssh.CertChecker{
+		IsHostAuthority: func(ssh.PublicKey, string) bool { return true },
+	}
+	if err := checker.CheckHostKey(hostname, remote, cert); err != nil {
+		return fmt.Errorf("host certificate for %s rejected: %w", host, err)
+	}
+
+	return nil
+}
+
+// expandHome expands a leading ~ to the user's home directory
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return home + path[1:]
+}
@@ -1,7 +1,7 @@
 package main
 
 import (
-	"bufio"
+	"encoding/base64"
 	"fmt"
 	"net"
 	"os"
@@ -34,6 +34,19 @@ func InitKnownHosts() error {
 		return nil
 	}
 
+	// Another sshclient process may be creating the file at the same time
+	return withFileLock(sshClientKnownHosts, func() error {
+		return createKnownHosts(sshClientKnownHosts)
+	})
+}
+
+// createKnownHosts creates sshclient's known_hosts, copying ~/.ssh/known_hosts
+// if it exists (the caller holds the file's lock)
+func createKnownHosts(sshClientKnownHosts string) error {
+	if _, err := os.Stat(sshClientKnownHosts); err == nil {
+		return nil
+	}
+
 	// Check if ~/.ssh/known_hosts exists
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -56,7 +69,7 @@ func InitKnownHosts() error {
 			return fmt.Errorf("failed to create config directory: %w", err)
 		}
 
-		if err := os.WriteFile(sshClientKnownHosts, input, 0600); err != nil {
+		if err := writeFileAtomic(sshClientKnownHosts, input, 0600); err != nil {
 			return fmt.Errorf("failed to write known_hosts: %w", err)
 		}
 
@@ -68,7 +81,7 @@ func InitKnownHosts() error {
 			return fmt.Errorf("failed to create config directory: %w", err)
 		}
 
-		if err := os.WriteFile(sshClientKnownHosts, []byte{}, 0600); err != nil {
+		if err := writeFileAtomic(sshClientKnownHosts, []byte{}, 0600); err != nil {
 			return fmt.Errorf("failed to create known_hosts: %w", err)
 		}
 	}
@@ -76,27 +89,118 @@ func InitKnownHosts() error {
 	return nil
 }
 
+// StrictHostKeyChecking modes, as in OpenSSH
+const (
+	StrictHostKeyYes       = "yes"        // Reject unknown hosts
+	StrictHostKeyAcceptNew = "accept-new" // Trust and record unknown hosts without asking
+	StrictHostKeyAsk       = "ask"        // Ask before trusting unknown hosts (default)
+	StrictHostKeyOff       = "off"        // Trust and record unknown hosts with a warning
+)
+
+// HostKeyOptions configures host key verification for a connection
+type HostKeyOptions struct {
+	HostCAs               []string // Trusted host CA keys (public key lines or file paths)
+	StrictHostKeyChecking string   // yes, accept-new, ask or off (ask if empty)
+	HashKnownHosts        bool     // Write new known_hosts entries hashed
+	UpdateHostKeys        string   // Learn keys announced by the server: yes (default) or no
+	Fingerprints          []string // Pinned SHA256 fingerprints, replace known_hosts when set
+	KnownHostsFile        string   // known_hosts file to use instead of sshclient's own
+}
+
+// knownHostsPath returns the known_hosts file for the options, creating
+// it if it does not exist yet
+func (opts HostKeyOptions) knownHostsPath() (string, error) {
+	if opts.KnownHostsFile == "" {
+		if err := InitKnownHosts(); err != nil {
+			return "", err
+		}
+		return GetKnownHostsPath()
+	}
+
+	path := expandHome(opts.KnownHostsFile)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		return path, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return "", fmt.Errorf("failed to create known_hosts directory: %w", err)
+	}
+	err := withFileLock(path, func() error {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			return nil
+		}
+		return writeFileAtomic(path, []byte{}, 0600)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create known_hosts: %w", err)
+	}
+	return path, nil
+}
+
+// ParseStrictHostKeyChecking validates a StrictHostKeyChecking value
+// "no" is accepted as an alias for "off", like OpenSSH does
+func ParseStrictHostKeyChecking(value string) (string, error) {
+	switch mode := strings.ToLower(strings.TrimSpace(value)); mode {
+	case "":
+		return StrictHostKeyAsk, nil
+	case StrictHostKeyYes, StrictHostKeyAcceptNew, StrictHostKeyAsk, StrictHostKeyOff:
+		return mode, nil
+	case "no":
+		return StrictHostKeyOff, nil
+	default:
+		return "", fmt.Errorf("invalid StrictHostKeyChecking value %q (use yes, accept-new, ask or off)", value)
+	}
+}
+
 // GetHostKeyCallback returns a callback function for host key verification
-func GetHostKeyCallback() (ssh.HostKeyCallback, error) {
-	// Initialize known_hosts if needed
-	if err := InitKnownHosts(); err != nil {
+// Host certificates signed by a trusted CA (an @cert-authority line in
+// known_hosts or one of opts.HostCAs) are accepted without a prompt.
+// Unknown hosts are handled according to opts.StrictHostKeyChecking; a
+// changed key is always rejected unless the user confirms the update in ask mode.
+// Pinned fingerprints bypass all of this: only those keys are accepted.
+func GetHostKeyCallback(opts HostKeyOptions) (ssh.HostKeyCallback, error) {
+	mode, err := ParseStrictHostKeyChecking(opts.StrictHostKeyChecking)
+	if err != nil {
 		return nil, err
 	}
 
-	knownHostsPath, err := GetKnownHostsPath()
+	if len(opts.Fingerprints) > 0 {
+		return pinnedHostKeyCallback(opts.Fingerprints)
+	}
+
+	knownHostsPath, err := opts.knownHostsPath()
 	if err != nil {
 		return nil, err
 	}
 
-	// Create a callback that handles unknown hosts
-	callback, err := knownhosts.New(knownHostsPath)
+	hostCAs, err := ParseHostCAs(opts.HostCAs)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create known_hosts callback: %w", err)
+		return nil, err
+	}
+
+	// Report a broken file now rather than on the first connection
+	if _, _, err := loadKnownHosts(knownHostsPath); err != nil {
+		return nil, err
 	}
 
 	// Wrap the callback to handle unknown hosts
 	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
-		err := callback(hostname, remote, key)
+		// Read the file for every check, so hosts accepted since the client
+		// was created (e.g. before a reconnect) are known
+		lines, callback, err := loadKnownHosts(knownHostsPath)
+		if err != nil {
+			return err
+		}
+
+		if cert, ok := key.(*ssh.Certificate); ok {
+			host, port := splitHostPort(hostname)
+			if isTrustedHostCA(cert.SignatureKey, host, port, lines, hostCAs) {
+				return verifyHostCertificate(hostname, remote, cert, lines)
+			}
+			// No trusted CA signed it, treat it like a plain host key
+			key = cert.Key
+		}
+
+		err = callback(hostname, remote, key)
 		if err == nil {
 			// Host key is already known and matches
 			return nil
@@ -104,22 +208,86 @@ func GetHostKeyCallback() (ssh.HostKeyCallback, error) {
 
 		// Check if this is an unknown host
 		if keyErr, ok := err.(*knownhosts.KeyError); ok && len(keyErr.Want) == 0 {
+			switch mode {
+			case StrictHostKeyYes:
+				return fmt.Errorf("host key verification failed: no host key is known for %s and StrictHostKeyChecking is yes (%s key fingerprint is %s)",
+					hostname, key.Type(), ssh.FingerprintSHA256(key))
+			case StrictHostKeyAcceptNew:
+				return acceptNewHost(hostname, remote, key, knownHostsPath, false, opts.HashKnownHosts)
+			case StrictHostKeyOff:
+				return acceptNewHost(hostname, remote, key, knownHostsPath, true, opts.HashKnownHosts)
+			}
 			// Unknown host - ask user
-			return handleUnknownHost(hostname, remote, key, knownHostsPath)
+			return handleUnknownHost(hostname, remote, key, knownHostsPath, opts.HashKnownHosts)
 		}
 
 		// Host key has changed or other error
 		if keyErr, ok := err.(*knownhosts.KeyError); ok && len(keyErr.Want) > 0 {
 			// Key mismatch - potential security issue
-			return handleKeyMismatch(hostname, remote, key, keyErr, knownHostsPath)
+			// Only ask mode lets the user replace the key, the other modes run unattended
+			return handleKeyMismatch(hostname, remote, key, keyErr, knownHostsPath, mode == StrictHostKeyAsk, opts.HashKnownHosts)
 		}
 
 		return err
 	}, nil
 }
 
+// loadKnownHosts reads known_hosts for both the CA checks and the
+// knownhosts package's callback
+func loadKnownHosts(knownHostsPath string) ([]knownHostsLine, ssh.HostKeyCallback, error) {
+	lines, err := readKnownHosts(knownHostsPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read known_hosts: %w", err)
+	}
+	callback, err := knownhosts.New(knownHostsPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create known_hosts callback: %w", err)
+	}
+	return lines, callback, nil
+}
+
+// NormalizeFingerprint validates a SHA256 fingerprint and returns it in the
+// form produced by ssh.FingerprintSHA256 ("SHA256:" prefix, no padding)
+func NormalizeFingerprint(fingerprint string) (string, error) {
+	fp := strings.TrimRight(strings.TrimSpace(fingerprint), "=")
+	if !strings.HasPrefix(fp, "SHA256:") {
+		return "", fmt.Errorf("invalid host key fingerprint %q: only SHA256:... fingerprints are supported", fingerprint)
+	}
+	if _, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(fp, "SHA256:")); err != nil {
+		return "", fmt.Errorf("invalid host key fingerprint %q: %w", fingerprint, err)
+	}
+	return fp, nil
+}
+
+// pinnedHostKeyCallback accepts only host keys with one of the given
+// fingerprints, without prompting or consulting known_hosts
+// For a host certificate the fingerprint of the certified key is checked.
+func pinnedHostKeyCallback(fingerprints []string) (ssh.HostKeyCallback, error) {
+	pinned := make(map[string]bool)
+	for _, fingerprint := range fingerprints {
+		fp, err := NormalizeFingerprint(fingerprint)
+		if err != nil {
+			return nil, err
+		}
+		pinned[fp] = true
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if cert, ok := key.(*ssh.Certificate); ok {
+			key = cert.Key
+		}
+
+		fingerprint := ssh.FingerprintSHA256(key)
+		if !pinned[fingerprint] {
+			return fmt.Errorf("host key verification failed: %s key fingerprint %s for %s does not match the pinned fingerprints",
+				key.Type(), fingerprint, hostname)
+		}
+		return nil
+	}, nil
+}
+
 // handleUnknownHost prompts the user to accept a new host key
-func handleUnknownHost(hostname string, remote net.Addr, key ssh.PublicKey, knownHostsPath string) error {
+func handleUnknownHost(hostname string, remote net.Addr, key ssh.PublicKey, knownHostsPath string, hash bool) error {
 	fingerprint := ssh.FingerprintSHA256(key)
 
 	fmt.Fprintf(os.Stderr, "\n⚠️  WARNING: Unknown host!\n")
@@ -129,8 +297,7 @@ func handleUnknownHost(hostname string, remote net.Addr, key ssh.PublicKey, know
 
 	// Ask user
 	fmt.Fprintf(os.Stderr, "Are you sure you want to continue connecting (yes/no)? ")
-	reader := bufio.NewReader(os.Stdin)
-	response, err := reader.ReadString('\n')
+	response, err := stdinReader.ReadString('\n')
 	if err != nil {
 		return fmt.Errorf("failed to read user input: %w", err)
 	}
@@ -141,7 +308,7 @@ func handleUnknownHost(hostname string, remote net.Addr, key ssh.PublicKey, know
 	}
 
 	// Add to known_hosts
-	if err := addHostKey(hostname, remote, key, knownHostsPath); err != nil {
+	if err := addHostKey(hostname, remote, key, knownHostsPath, hash); err != nil {
 		return fmt.Errorf("failed to add host key: %w", err)
 	}
 
@@ -149,8 +316,21 @@ func handleUnknownHost(hostname string, remote net.Addr, key ssh.PublicKey, know
 	return nil
 }
 
+// acceptNewHost records the key of an unknown host without asking
+func acceptNewHost(hostname string, remote net.Addr, key ssh.PublicKey, knownHostsPath string, warn, hash bool) error {
+	if err := addHostKey(hostname, remote, key, knownHostsPath, hash); err != nil {
+		return fmt.Errorf("failed to add host key: %w", err)
+	}
+
+	if warn {
+		fmt.Fprintf(os.Stderr, "⚠️  Warning: Permanently added '%s' (%s) to the list of known hosts.\n", hostname, key.Type())
+	}
+	return nil
+}
+
 // handleKeyMismatch handles the case where a host key has changed
-func handleKeyMismatch(hostname string, remote net.Addr, key ssh.PublicKey, keyErr *knownhosts.KeyError, knownHostsPath string) error {
+// The user is only offered to replace the key when allowUpdate is set
+func handleKeyMismatch(hostname string, remote net.Addr, key ssh.PublicKey, keyErr *knownhosts.KeyError, knownHostsPath string, allowUpdate, hash bool) error {
 	fingerprint := ssh.FingerprintSHA256(key)
 
 	fmt.Fprintf(os.Stderr, "\n❌ WARNING: HOST KEY HAS CHANGED!\n")
@@ -167,10 +347,13 @@ func handleKeyMismatch(hostname string, remote net.Addr, key ssh.PublicKey, keyE
 	}
 	fmt.Fprintf(os.Stderr, "\n")
 
+	if !allowUpdate {
+		return fmt.Errorf("host key verification failed: key mismatch (remove the old key from %s to accept the new one)", knownHostsPath)
+	}
+
 	// Ask user if they want to update (risky!)
 	fmt.Fprintf(os.Stderr, "Do you want to update the host key? This is DANGEROUS! (yes/no)? ")
-	reader := bufio.NewReader(os.Stdin)
-	response, err := reader.ReadString('\n')
+	response, err := stdinReader.ReadString('\n')
 	if err != nil {
 		return fmt.Errorf("failed to read user input: %w", err)
 	}
@@ -181,11 +364,17 @@ func handleKeyMismatch(hostname string, remote net.Addr, key ssh.PublicKey, keyE
 	}
 
 	// Remove old keys and add new one
-	if err := removeHostKey(hostname, knownHostsPath); err != nil {
+	if _, err := removeHostKey(hostname, knownHostsPath); err != nil {
 		return fmt.Errorf("failed to remove old host key: %w", err)
 	}
+	if tcpAddr, ok := remote.(*net.TCPAddr); ok && !tcpAddr.IP.IsUnspecified() {
+		_, port := splitHostPort(hostname)
+		if _, err := removeHostKey(net.JoinHostPort(tcpAddr.IP.String(), port), knownHostsPath); err != nil {
+			return fmt.Errorf("failed to remove old host key: %w", err)
+		}
+	}
 
-	if err := addHostKey(hostname, remote, key, knownHostsPath); err != nil {
+	if err := addHostKey(hostname, remote, key, knownHostsPath, hash); err != nil {
 		return fmt.Errorf("failed to add new host key: %w", err)
 	}
 
@@ -194,67 +383,86 @@ func handleKeyMismatch(hostname string, remote net.Addr, key ssh.PublicKey, keyE
 }
 
 // addHostKey adds a host key to the known_hosts file
-func addHostKey(hostname string, remote net.Addr, key ssh.PublicKey, knownHostsPath string) error {
-	// Format: hostname ssh-rsa AAAAB3...
-	host := hostname
-	// Also add IP address if different
-	if tcpAddr, ok := remote.(*net.TCPAddr); ok {
-		ip := tcpAddr.IP.String()
-		if ip != hostname {
-			host = hostname + "," + ip
-		}
+// Names follow the OpenSSH convention (host for port 22, [host]:port
+// otherwise). With hash set, each name is written on its own line as
+// |1|salt|hash so the file does not reveal which hosts were visited.
+func addHostKey(hostname string, remote net.Addr, key ssh.PublicKey, knownHostsPath string, hash bool) error {
+	// UserKnownHostsFile /dev/null: keys are accepted but never recorded
+	if knownHostsPath == os.DevNull {
+		return nil
 	}
 
-	line := knownhosts.Line([]string{host}, key)
+	host, port := splitHostPort(hostname)
+	names := []string{knownhosts.Normalize(net.JoinHostPort(host, port))}
 
-	// Append to file
-	f, err := os.OpenFile(knownHostsPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
-	if err != nil {
-		return err
+	// Also add IP address if different
+	// Connections through a jump host report an unspecified address, skip it
+	if tcpAddr, ok := remote.(*net.TCPAddr); ok && !tcpAddr.IP.IsUnspecified() {
+		ip := tcpAddr.IP.String()
+		if ip != host {
+			names = append(names, knownhosts.Normalize(net.JoinHostPort(ip, port)))
+		}
 	}
-	defer f.Close()
 
-	if _, err := f.WriteString(line + "\n"); err != nil {
-		return err
+	var lines []string
+	if hash {
+		for _, name := range names {
+			lines = append(lines, knownhosts.Line([]string{knownhosts.HashHostname(name)}, key))
+		}
+	} else {
+		lines = append(lines, knownhosts.Line(names, key))
 	}
 
-	return nil
+	// Append to the file, rewriting it atomically so that concurrent
+	// sshclient processes cannot lose each other's entries
+	return updateFileAtomic(knownHostsPath, 0600, func(data []byte) ([]byte, error) {
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			data = append(data, '\n')
+		}
+		return append(data, strings.Join(lines, "\n")+"\n"...), nil
+	})
 }
 
-// removeHostKey removes all entries for a hostname from known_hosts
-func removeHostKey(hostname string, knownHostsPath string) error {
-	input, err := os.ReadFile(knownHostsPath)
-	if err != nil {
-		return err
-	}
-
-	lines := strings.Split(string(input), "\n")
-	var newLines []string
-
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" || strings.HasPrefix(line, "#") {
-			newLines = append(newLines, line)
-			continue
-		}
+// removeHostKey removes all entries for a hostname from known_hosts and
+// returns how many were removed
+// The hostname may be host, host:port or [host]:port, and hashed entries
+// are matched too. @cert-authority and @revoked lines are kept.
+func removeHostKey(hostname string, knownHostsPath string) (int, error) {
+	target := knownhosts.Normalize(hostname)
+	removed := 0
+
+	err := updateFileAtomic(knownHostsPath, 0600, func(input []byte) ([]byte, error) {
+		lines := strings.Split(string(input), "\n")
+		var newLines []string
+		removed = 0
+
+		for _, line := range lines {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "@") {
+				newLines = append(newLines, line)
+				continue
+			}
 
-		// Check if this line contains the hostname
-		parts := strings.Fields(line)
-		if len(parts) > 0 {
-			hosts := strings.Split(parts[0], ",")
-			match := false
-			for _, h := range hosts {
-				if h == hostname {
-					match = true
-					break
+			// Check if this line contains the hostname
+			parts := strings.Fields(line)
+			if len(parts) > 0 {
+				hosts := strings.Split(parts[0], ",")
+				match := false
+				for _, h := range hosts {
+					if strings.EqualFold(h, target) || (strings.HasPrefix(h, "|1|") && matchHashedHost(h, target)) {
+						match = true
+						break
+					}
+				}
+				if match {
+					removed++
+				} else {
+					newLines = append(newLines, line)
 				}
-			}
-			if !match {
-				newLines = append(newLines, line)
 			}
 		}
-	}
 
-	output := strings.Join(newLines, "\n")
-	return os.WriteFile(knownHostsPath, []byte(output), 0600)
+		return []byte(strings.Join(newLines, "\n")), nil
+	})
+	return removed, err
 }
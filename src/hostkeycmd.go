@@ -0,0 +1,512 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// scanKeyAlgorithms are the host key types fetched by hostkey scan, one
+// handshake each (like ssh-keyscan)
+var scanKeyAlgorithms = []string{
+	ssh.KeyAlgoED25519,
+	ssh.KeyAlgoECDSA256,
+	ssh.KeyAlgoECDSA384,
+	ssh.KeyAlgoECDSA521,
+	ssh.KeyAlgoRSASHA512,
+}
+
+// errScanDone aborts a scan handshake once the host key has been received
+var errScanDone = errors.New("host key received")
+
+// ScanHostKeys fetches the host keys offered by a server without
+// authenticating
+func ScanHostKeys(host, port string, timeout time.Duration) ([]ssh.PublicKey, error) {
+	addr := net.JoinHostPort(host, port)
+	var keys []ssh.PublicKey
+	var dialErr error
+
+	for _, algorithm := range scanKeyAlgorithms {
+		// A server may refuse some of the connections (e.g. MaxStartups),
+		// which only loses the keys of that type
+		conn, err := net.DialTimeout("tcp", addr, timeout)
+		if err != nil {
+			dialErr = err
+			continue
+		}
+		conn.SetDeadline(time.Now().Add(timeout))
+
+		var received ssh.PublicKey
+		config := &ssh.ClientConfig{
+			HostKeyAlgorithms: []string{algorithm},
+			HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+				received = key
+				return errScanDone
+			},
+			Timeout: timeout,
+		}
+		ssh.NewClientConn(conn, addr, config)
+		conn.Close()
+
+		// Servers without a key of this type fail the handshake, which is expected
+		if received == nil {
+			continue
+		}
+		if !containsKey(keys, received) {
+			keys = append(keys, received)
+		}
+	}
+
+	if len(keys) == 0 {
+		if dialErr != nil {
+			return nil, fmt.Errorf("failed to connect to %s: %w", addr, dialErr)
+		}
+		return nil, fmt.Errorf("no host keys received from %s", addr)
+	}
+	return keys, nil
+}
+
+// containsKey reports whether keys contains key
+func containsKey(keys []ssh.PublicKey, key ssh.PublicKey) bool {
+	for _, k := range keys {
+		if keysEqual(k, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHostArg parses a hostkey command target: host, host:port,
+// [host]:port or @profile
+func parseHostArg(arg string) (string, string, error) {
+	if strings.HasPrefix(arg, "@") {
+		profile, err := FindProfile(strings.TrimPrefix(arg, "@"))
+		if err != nil {
+			return "", "", err
+		}
+		port := profile.Port
+		if port == "" {
+			port = "22"
+		}
+		return profile.Host, port, nil
+	}
+
+	if strings.HasPrefix(arg, "[") || strings.Count(arg, ":") == 1 {
+		host, port, err := net.SplitHostPort(arg)
+		if err != nil {
+			return "", "", fmt.Errorf("invalid host %q: %w", arg, err)
+		}
+		return host, port, nil
+	}
+
+	return arg, "22", nil
+}
+
+// printFingerprints prints the SHA256 and MD5 fingerprints of a key
+func printFingerprints(key ssh.PublicKey, indent string) {
+	fmt.Printf("%s%s %s\n", indent, key.Type(), ssh.FingerprintSHA256(key))
+	fmt.Printf("%s%s MD5:%s\n", indent, strings.Repeat(" ", len(key.Type())), ssh.FingerprintLegacyMD5(key))
+}
+
+// describePatterns formats the host patterns of a line, hiding hashed names
+func describePatterns(line knownHostsLine) string {
+	patterns := make([]string, len(line.Patterns))
+	for i, pattern := range line.Patterns {
+		if strings.HasPrefix(pattern, "|1|") {
+			pattern = "(hashed)"
+		}
+		patterns[i] = pattern
+	}
+	return strings.Join(patterns, ",")
+}
+
+// HostKeyList lists every entry in known_hosts
+func HostKeyList() error {
+	knownHostsPath, err := GetKnownHostsPath()
+	if err != nil {
+		return err
+	}
+
+	lines, err := readKnownHosts(knownHostsPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			fmt.Println("No known hosts.")
+			return nil
+		}
+		return err
+	}
+
+	if len(lines) == 0 {
+		fmt.Println("No known hosts.")
+		return nil
+	}
+
+	fmt.Printf("🔑 Known hosts (%s):\n", knownHostsPath)
+	fmt.Println(strings.Repeat("─", 70))
+	for _, line := range lines {
+		marker := ""
+		if line.Marker != "" {
+			marker = line.Marker + " "
+		}
+		fmt.Printf("  %4d  %s%s\n", line.LineNum, marker, describePatterns(line))
+		fmt.Printf("        %s %s\n", line.Key.Type(), ssh.FingerprintSHA256(line.Key))
+	}
+
+	return nil
+}
+
+// HostKeyShow displays the entries that apply to a host, including
+// @cert-authority and @revoked lines
+func HostKeyShow(target string) error {
+	host, port, err := parseHostArg(target)
+	if err != nil {
+		return err
+	}
+
+	knownHostsPath, err := GetKnownHostsPath()
+	if err != nil {
+		return err
+	}
+
+	lines, err := readKnownHosts(knownHostsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	address := knownHostsAddress(host, port)
+	fmt.Printf("Host: %s\n", address)
+	fmt.Println(strings.Repeat("─", 40))
+
+	found := false
+	for _, line := range lines {
+		// Revoked keys apply to every host
+		if line.Marker != markerRevoked && !line.matches(host, port) {
+			continue
+		}
+		found = true
+
+		label := "key"
+		switch line.Marker {
+		case markerCertAuthority:
+			label = "host CA"
+		case markerRevoked:
+			label = "REVOKED"
+		}
+		fmt.Printf("  line %d (%s, %s):\n", line.LineNum, label, describePatterns(line))
+		printFingerprints(line.Key, "    ")
+	}
+
+	if !found {
+		fmt.Printf("  No entries for %s\n", address)
+		fmt.Printf("\nFetch the server's keys with: sshclient hostkey scan %s\n", target)
+	}
+	return nil
+}
+
+// HostKeyRemove removes the keys recorded for a host
+func HostKeyRemove(target string) error {
+	host, port, err := parseHostArg(target)
+	if err != nil {
+		return err
+	}
+
+	knownHostsPath, err := GetKnownHostsPath()
+	if err != nil {
+		return err
+	}
+
+	removed, err := removeHostKey(net.JoinHostPort(host, port), knownHostsPath)
+	if err != nil {
+		return fmt.Errorf("failed to remove host key: %w", err)
+	}
+	if removed == 0 {
+		return fmt.Errorf("no host keys found for %s", knownHostsAddress(host, port))
+	}
+
+	fmt.Printf("✓ Removed %d key(s) for %s\n", removed, knownHostsAddress(host, port))
+	return nil
+}
+
+// HostKeyAdd records keys for a host, read from a file (public key or
+// ssh-keyscan format) or fetched from the server after confirmation
+// With hash set, the host name is stored hashed.
+func HostKeyAdd(target, keyFile string, hash bool) error {
+	host, port, err := parseHostArg(target)
+	if err != nil {
+		return err
+	}
+
+	if err := InitKnownHosts(); err != nil {
+		return err
+	}
+	knownHostsPath, err := GetKnownHostsPath()
+	if err != nil {
+		return err
+	}
+
+	var keys []ssh.PublicKey
+	if keyFile != "" {
+		keys, err = readPublicKeys(keyFile)
+		if err != nil {
+			return err
+		}
+	} else {
+		keys, err = ScanHostKeys(host, port, 10*time.Second)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("Keys offered by %s:\n", knownHostsAddress(host, port))
+		for _, key := range keys {
+			printFingerprints(key, "  ")
+		}
+		if !promptYesNo("\nAdd these keys to known_hosts?") {
+			return fmt.Errorf("cancelled")
+		}
+	}
+
+	hostname := net.JoinHostPort(host, port)
+	added := 0
+	for _, key := range keys {
+		if hostKeyKnown(host, port, key, knownHostsPath) {
+			fmt.Printf("  %s already known\n", key.Type())
+			continue
+		}
+		if err := addHostKey(hostname, nil, key, knownHostsPath, hash); err != nil {
+			return fmt.Errorf("failed to add host key: %w", err)
+		}
+		added++
+	}
+
+	fmt.Printf("✓ Added %d key(s) for %s\n", added, knownHostsAddress(host, port))
+	return nil
+}
+
+// readPublicKeys reads keys from a file in authorized_keys, .pub or
+// ssh-keyscan ("host keytype key") format
+func readPublicKeys(path string) ([]ssh.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key file: %w", err)
+	}
+
+	var keys []ssh.PublicKey
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(text))
+		if err != nil {
+			// ssh-keyscan output starts with the host name
+			line, ok := parseKnownHostsLine(text)
+			if !ok {
+				return nil, fmt.Errorf("failed to parse key in %s: %w", path, err)
+			}
+			key = line.Key
+		}
+		keys = append(keys, key)
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys found in %s", path)
+	}
+	return keys, nil
+}
+
+// hostKeyKnown reports whether key is already recorded for host:port
+func hostKeyKnown(host, port string, key ssh.PublicKey, knownHostsPath string) bool {
+	lines, err := readKnownHosts(knownHostsPath)
+	if err != nil {
+		return false
+	}
+	for _, line := range lines {
+		if line.Marker == "" && keysEqual(line.Key, key) && line.matches(host, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// HostKeyScan prints a server's host keys in known_hosts format, like
+// ssh-keyscan; fingerprints go to stderr so the output can be redirected
+func HostKeyScan(target string) error {
+	host, port, err := parseHostArg(target)
+	if err != nil {
+		return err
+	}
+
+	keys, err := ScanHostKeys(host, port, 10*time.Second)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		fmt.Fprintf(os.Stderr, "# %s %s %s\n", knownHostsAddress(host, port), key.Type(), ssh.FingerprintSHA256(key))
+		fmt.Println(knownhosts.Line([]string{net.JoinHostPort(host, port)}, key))
+	}
+	return nil
+}
+
+// HostKeyVerify compares the keys a server offers with known_hosts and fails
+// if any of them is revoked or does not match a recorded key
+func HostKeyVerify(target string) error {
+	host, port, err := parseHostArg(target)
+	if err != nil {
+		return err
+	}
+
+	knownHostsPath, err := GetKnownHostsPath()
+	if err != nil {
+		return err
+	}
+	lines, err := readKnownHosts(knownHostsPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	keys, err := ScanHostKeys(host, port, 10*time.Second)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Host: %s\n", knownHostsAddress(host, port))
+	fmt.Println(strings.Repeat("─", 40))
+
+	failed := false
+	for _, key := range keys {
+		printFingerprints(key, "  ")
+
+		if isRevokedHostKey(key, lines) {
+			fmt.Printf("    ❌ REVOKED in known_hosts\n")
+			failed = true
+			continue
+		}
+
+		matched, sameType := 0, false
+		for _, line := range lines {
+			if line.Marker != "" || !line.matches(host, port) {
+				continue
+			}
+			if keysEqual(line.Key, key) {
+				matched = line.LineNum
+				break
+			}
+			if line.Key.Type() == key.Type() {
+				sameType = true
+			}
+		}
+
+		switch {
+		case matched > 0:
+			fmt.Printf("    ✅ matches known_hosts line %d\n", matched)
+		case sameType:
+			fmt.Printf("    ❌ DOES NOT MATCH the recorded %s key\n", key.Type())
+			failed = true
+		default:
+			fmt.Printf("    ⚠️  not in known_hosts\n")
+		}
+	}
+
+	if failed {
+		return fmt.Errorf("host key verification failed for %s", knownHostsAddress(host, port))
+	}
+	return nil
+}
+
+// PrintHostKeyHelp prints hostkey command usage help
+func PrintHostKeyHelp() {
+	fmt.Println("Host Key Management - Manage ~/.sshclient/known_hosts")
+	fmt.Println()
+	fmt.Println("Usage:")
+	fmt.Println("  sshclient hostkey <command> [arguments]")
+	fmt.Println()
+	fmt.Println("Hosts can be given as host, host:port, [host]:port or @profile.")
+	fmt.Println()
+	fmt.Println("Available Commands:")
+	fmt.Println("  list, ls               List all known host keys")
+	fmt.Println("  show <host>            Show keys for a host (SHA256 and MD5)")
+	fmt.Println("  remove <host>          Remove the keys recorded for a host")
+	fmt.Println("  add [-hash] <host> [key-file]")
+	fmt.Println("                         Add keys from a file, or fetch them from the server")
+	fmt.Println("  scan <host>            Print the server's keys in known_hosts format")
+	fmt.Println("  verify <host>          Compare the server's keys with known_hosts")
+	fmt.Println()
+	fmt.Println("Examples:")
+	fmt.Println("  sshclient hostkey show example.com")
+	fmt.Println("  sshclient hostkey remove [example.com]:2222")
+	fmt.Println("  sshclient hostkey scan @myserver >> keys.txt")
+	fmt.Println("  sshclient hostkey add example.com keys.txt")
+}
+
+// HandleHostKeyCommand handles host key management commands
+func HandleHostKeyCommand(args []string) error {
+	if len(args) < 1 {
+		PrintHostKeyHelp()
+		return nil
+	}
+
+	subcommand := args[0]
+
+	// Every command except list needs a host
+	switch subcommand {
+	case "show", "remove", "rm", "add", "scan", "verify":
+		if len(args) < 2 {
+			fmt.Printf("Error: hostkey %s requires a host\n", subcommand)
+			fmt.Println()
+			PrintHostKeyHelp()
+			return fmt.Errorf("missing host")
+		}
+	}
+
+	switch subcommand {
+	case "list", "ls":
+		return HostKeyList()
+
+	case "show":
+		return HostKeyShow(args[1])
+
+	case "remove", "rm":
+		return HostKeyRemove(args[1])
+
+	case "add":
+		hash := false
+		if args[1] == "-hash" {
+			hash = true
+			args = args[1:]
+		}
+		if len(args) < 2 {
+			return fmt.Errorf("missing host")
+		}
+		keyFile := ""
+		if len(args) > 2 {
+			keyFile = args[2]
+		}
+		return HostKeyAdd(args[1], keyFile, hash)
+
+	case "scan":
+		return HostKeyScan(args[1])
+
+	case "verify":
+		return HostKeyVerify(args[1])
+
+	case "help", "-h", "--help":
+		PrintHostKeyHelp()
+		return nil
+
+	default:
+		fmt.Printf("Error: unknown hostkey subcommand: %s\n", subcommand)
+		fmt.Println()
+		PrintHostKeyHelp()
+		return fmt.Errorf("unknown subcommand: %s", subcommand)
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func TestScanHostKeysKeepsKeysWhenLaterConnectionsFail(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	// Only the first connection (ed25519) is served, later ones are refused
+	go func() {
+		conn, err := listener.Accept()
+		listener.Close()
+		if err != nil {
+			return
+		}
+		ssh.NewServerConn(conn, config)
+		conn.Close()
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	keys, err := ScanHostKeys(host, port, 5*time.Second)
+	if err != nil {
+		t.Fatalf("ScanHostKeys error: %v", err)
+	}
+	if len(keys) != 1 || ssh.FingerprintSHA256(keys[0]) != ssh.FingerprintSHA256(signer.PublicKey()) {
+		t.Errorf("ScanHostKeys returned %d keys, want the ed25519 host key", len(keys))
+	}
+
+	// Nothing listens any more
+	if _, err := ScanHostKeys(host, port, time.Second); err == nil || !strings.Contains(err.Error(), "failed to connect") {
+		t.Errorf("ScanHostKeys without a server: error = %v, want a connection error", err)
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Global requests used by OpenSSH servers to announce all of their host keys
+// (see PROTOCOL in the OpenSSH sources, "hostkeys-00@openssh.com")
+const (
+	hostKeysRequest      = "hostkeys-00@openssh.com"
+	hostKeysProveRequest = "hostkeys-prove-00@openssh.com"
+)
+
+// UpdateHostKeys values
+const (
+	UpdateHostKeysYes = "yes" // Learn keys announced by the server (default)
+	UpdateHostKeysNo  = "no"  // Ignore announced keys
+)
+
+// ParseUpdateHostKeys validates an UpdateHostKeys value
+func ParseUpdateHostKeys(value string) (string, error) {
+	switch mode := strings.ToLower(strings.TrimSpace(value)); mode {
+	case "":
+		return UpdateHostKeysYes, nil
+	case UpdateHostKeysYes, UpdateHostKeysNo:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid UpdateHostKeys value %q (use yes or no)", value)
+	}
+}
+
+// filterHostKeyRequests passes global requests through to the returned
+// channel, except hostkeys-00@openssh.com announcements which are handled
+// by learnHostKeys
+func (c *SSHClient) filterHostKeyRequests(conn ssh.Conn, hostname string, hostKey ssh.PublicKey, reqs <-chan *ssh.Request) <-chan *ssh.Request {
+	out := make(chan *ssh.Request)
+
+	go func() {
+		defer close(out)
+		for req := range reqs {
+			if req.Type != hostKeysRequest {
+				out <- req
+				continue
+			}
+
+			if req.WantReply {
+				req.Reply(false, nil)
+			}
+			if c.hostKeys.UpdateHostKeys == UpdateHostKeysNo || hostKey == nil {
+				continue
+			}
+
+			// Proving the keys needs a round trip, don't hold up other requests
+			payload := req.Payload
+			go func() {
+				if err := c.learnHostKeys(conn, hostname, hostKey, payload); err != nil {
+					fmt.Fprintf(os.Stderr, "Warning: failed to update host keys: %v\n", err)
+				}
+			}()
+		}
+	}()
+
+	return out
+}
+
+// learnHostKeys adds the host keys announced by the server to known_hosts
+// Keys are only learned when the key used for this connection is recorded
+// in known_hosts, and only after the server proves it holds the private keys.
+func (c *SSHClient) learnHostKeys(conn ssh.Conn, hostname string, hostKey ssh.PublicKey, payload []byte) error {
+	knownHostsPath, err := c.hostKeys.knownHostsPath()
+	if err != nil {
+		return err
+	}
+	lines, err := readKnownHosts(knownHostsPath)
+	if err != nil {
+		return err
+	}
+
+	host, port := splitHostPort(hostname)
+	if !isKnownHostKey(hostKey, host, port, lines) {
+		// Accepted through a CA, a pinned fingerprint or without recording it
+		return nil
+	}
+
+	announced, err := parseHostKeyList(payload)
+	if err != nil {
+		return err
+	}
+
+	var newKeys []ssh.PublicKey
+	for _, key := range announced {
+		if !isKnownHostKey(key, host, port, lines) && !isRevokedHostKey(key, lines) {
+			newKeys = append(newKeys, key)
+		}
+	}
+	if len(newKeys) == 0 {
+		return nil
+	}
+
+	if err := proveHostKeys(conn, newKeys); err != nil {
+		return err
+	}
+
+	for _, key := range newKeys {
+		if err := addHostKey(hostname, nil, key, knownHostsPath, c.hostKeys.HashKnownHosts); err != nil {
+			return err
+		}
+		fmt.Fprintf(os.Stderr, "Learned new host key for %s: %s %s\n",
+			knownHostsAddress(host, port), key.Type(), ssh.FingerprintSHA256(key))
+	}
+
+	return nil
+}
+
+// isKnownHostKey reports whether key is recorded for host:port
+func isKnownHostKey(key ssh.PublicKey, host, port string, lines []knownHostsLine) bool {
+	for _, line := range lines {
+		if line.Marker == "" && keysEqual(line.Key, key) && line.matches(host, port) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseHostKeyList parses a list of SSH strings, each holding a public key
+// Keys of unsupported types are skipped.
+func parseHostKeyList(data []byte) ([]ssh.PublicKey, error) {
+	var keys []ssh.PublicKey
+	for len(data) > 0 {
+		blob, rest, ok := parseSSHString(data)
+		if !ok {
+			return nil, fmt.Errorf("malformed %s request", hostKeysRequest)
+		}
+		data = rest
+
+		if key, err := ssh.ParsePublicKey(blob); err == nil {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+// proveHostKeys asks the server to sign the session identifier with each
+// of the keys and verifies the signatures
+func proveHostKeys(conn ssh.Conn, keys []ssh.PublicKey) error {
+	var payload []byte
+	for _, key := range keys {
+		payload = appendSSHString(payload, key.Marshal())
+	}
+
+	ok, reply, err := conn.SendRequest(hostKeysProveRequest, true, payload)
+	if err != nil {
+		return fmt.Errorf("%s request failed: %w", hostKeysProveRequest, err)
+	}
+	if !ok {
+		return fmt.Errorf("server refused to prove its host keys")
+	}
+
+	for _, key := range keys {
+		blob, rest, ok := parseSSHString(reply)
+		if !ok {
+			return fmt.Errorf("server sent too few host key signatures")
+		}
+		reply = rest
+
+		sig := new(ssh.Signature)
+		if err := ssh.Unmarshal(blob, sig); err != nil {
+			return fmt.Errorf("invalid host key signature: %w", err)
+		}
+
+		var data []byte
+		data = appendSSHString(data, []byte(hostKeysProveRequest))
+		data = appendSSHString(data, conn.SessionID())
+		data = appendSSHString(data, key.Marshal())
+		if err := key.Verify(data, sig); err != nil {
+			return fmt.Errorf("server failed to prove %s host key %s: %w", key.Type(), ssh.FingerprintSHA256(key), err)
+		}
+	}
+
+	return nil
+}
+
+// hostKeyRecorder wraps a host key callback to remember the key that was
+// accepted for the connection
+func hostKeyRecorder(callback ssh.HostKeyCallback, accepted *ssh.PublicKey) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		if err := callback(hostname, remote, key); err != nil {
+			return err
+		}
+		*accepted = key
+		return nil
+	}
+}
+
+// parseSSHString reads a uint32 length-prefixed string
+func parseSSHString(data []byte) (value, rest []byte, ok bool) {
+	if len(data) < 4 {
+		return nil, nil, false
+	}
+	length := uint32(data[0])<<24 | uint32(data[1])<<16 | uint32(data[2])<<8 | uint32(data[3])
+	if uint32(len(data)-4) < length {
+		return nil, nil, false
+	}
+	return data[4 : 4+length], data[4+length:], true
+}
+
+// appendSSHString appends a uint32 length-prefixed string
+func appendSSHString(data, value []byte) []byte {
+	length := len(value)
+	data = append(data, byte(length>>24), byte(length>>16), byte(length>>8), byte(length))
+	return append(data, value...)
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ParseJumpHosts splits a comma separated -J value into individual hops
+func ParseJumpHosts(value string) []string {
+	var hops []string
+	for _, hop := range strings.Split(value, ",") {
+		hop = strings.TrimSpace(hop)
+		if hop != "" {
+			hops = append(hops, hop)
+		}
+	}
+	return hops
+}
+
+// ResolveJumpHost resolves a jump host specification into a profile
+// "@name" refers to a saved profile or ~/.ssh/config host, a bare name is
+// looked up the same way and otherwise parsed as [user@]host[:port]
+// Without a user of its own a hop logs in as the local user, like in
+// OpenSSH, not as the target's user.
+func ResolveJumpHost(spec string) (*Profile, error) {
+	if strings.HasPrefix(spec, "@") {
+		return withJumpUser(FindProfile(strings.TrimPrefix(spec, "@")))
+	}
+
+	if !strings.ContainsAny(spec, "@:") {
+		if profile, err := FindProfile(spec); err == nil {
+			return withJumpUser(profile, nil)
+		}
+	}
+
+	profile := &Profile{
+		Name: spec,
+		Host: spec,
+		User: localUserName(),
+		Port: "22",
+	}
+
+	if u, h, ok := parseUserHost(spec); ok {
+		profile.User = u
+		profile.Host = h
+	}
+
+	if host, port, err := net.SplitHostPort(profile.Host); err == nil {
+		profile.Host = host
+		profile.Port = port
+	}
+
+	if profile.Host == "" {
+		return nil, fmt.Errorf("invalid jump host %q", spec)
+	}
+
+	return profile, nil
+}
+
+// withJumpUser fills in the local user name for a hop profile without a user
+func withJumpUser(profile *Profile, err error) (*Profile, error) {
+	if err != nil {
+		return nil, err
+	}
+	if profile.User == "" {
+		profile.User = localUserName()
+	}
+	return profile, nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+func TestResolveJumpHost(t *testing.T) {
+	writeSSHConfig(t, map[string]string{
+		"config": "Host bastion\n" +
+			"  HostName bastion.example.com\n" +
+			"  User jumper\n" +
+			"Host gateway\n" +
+			"  HostName gw.example.com\n" +
+			"  Port 2200\n",
+	})
+	local := localUserName()
+
+	tests := []struct {
+		spec    string
+		user    string
+		host    string
+		port    string
+		wantErr bool
+	}{
+		{spec: "bastion", user: "jumper", host: "bastion.example.com", port: "22"},
+		{spec: "@bastion", user: "jumper", host: "bastion.example.com", port: "22"},
+		{spec: "gateway", user: local, host: "gw.example.com", port: "2200"},
+		{spec: "other.example.com", user: local, host: "other.example.com", port: "22"},
+		{spec: "ops@other.example.com:2201", user: "ops", host: "other.example.com", port: "2201"},
+		{spec: "[2001:db8::1]:2202", user: local, host: "2001:db8::1", port: "2202"},
+		{spec: "@missing", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		profile, err := ResolveJumpHost(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ResolveJumpHost(%q) = %+v, want error", tt.spec, profile)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ResolveJumpHost(%q) error: %v", tt.spec, err)
+			continue
+		}
+		if profile.User != tt.user || profile.Host != tt.host || profile.Port != tt.port {
+			t.Errorf("ResolveJumpHost(%q) = %s@%s:%s, want %s@%s:%s",
+				tt.spec, profile.User, profile.Host, profile.Port, tt.user, tt.host, tt.port)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// keepAliveRequest is the global request OpenSSH sends as keepalive
+// Servers answer it (usually with a failure), which proves the link is up.
+const keepAliveRequest = "keepalive@openssh.com"
+
+// DefaultServerAliveCountMax is the number of unanswered keepalives after
+// which the connection is considered lost, as in OpenSSH
+const DefaultServerAliveCountMax = 3
+
+// ErrConnectionLost is returned by sessions whose connection was closed
+// because the server stopped answering keepalives
+var ErrConnectionLost = errors.New("connection lost: server stopped answering keepalive messages")
+
+// SetKeepAlive makes the client send a keepalive every interval while
+// connected and close the connection after countMax unanswered ones
+// A zero interval disables keepalives.
+func (c *SSHClient) SetKeepAlive(interval time.Duration, countMax int) {
+	if countMax <= 0 {
+		countMax = DefaultServerAliveCountMax
+	}
+	c.keepAliveInterval = interval
+	c.keepAliveCountMax = countMax
+}
+
+// startKeepAlive starts the keepalive loop for a new connection
+func (c *SSHClient) startKeepAlive() {
+	c.connectionLost.Store(false)
+	if c.keepAliveInterval > 0 {
+		go c.keepAlive(c.currentClient())
+	}
+}
+
+// keepAlive sends keepalive requests until the connection is closed
+// Only one request is outstanding at a time; every interval that passes
+// without its reply counts as a missed keepalive.
+func (c *SSHClient) keepAlive(client *ssh.Client) {
+	ticker := time.NewTicker(c.keepAliveInterval)
+	defer ticker.Stop()
+
+	closed := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(closed)
+	}()
+
+	replies := make(chan error, 1)
+	pending := false
+	missed := 0
+	for {
+		select {
+		case <-closed:
+			return
+
+		case err := <-replies:
+			if err != nil {
+				// The connection was closed while waiting for the reply
+				return
+			}
+			pending = false
+			missed = 0
+
+		case <-ticker.C:
+			if pending {
+				missed++
+				if missed >= c.keepAliveCountMax {
+					c.connectionLost.Store(true)
+					client.Close()
+					return
+				}
+				continue
+			}
+
+			pending = true
+			go func() {
+				_, _, err := client.SendRequest(keepAliveRequest, true, nil)
+				replies <- err
+			}()
+		}
+	}
+}
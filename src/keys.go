@@ -0,0 +1,186 @@
+package main
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// maxPassphraseAttempts is how many times the user is asked for a key passphrase
+const maxPassphraseAttempts = 3
+
+// ParsePrivateKeyFile reads and parses a private key file
+// Encrypted keys are decrypted with the given passphrase, or the user is
+// prompted if none is given. The passphrase that unlocked the key is returned
+// (empty for unencrypted keys).
+func ParsePrivateKeyFile(keyPath, passphrase string) (ssh.Signer, string, error) {
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to read private key: %w", err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(key)
+	if err == nil {
+		return signer, "", nil
+	}
+
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return nil, "", fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	// Try the stored passphrase first
+	if passphrase != "" {
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(key, []byte(passphrase))
+		if err == nil {
+			return signer, passphrase, nil
+		}
+		if err != x509.IncorrectPasswordError {
+			return nil, "", fmt.Errorf("failed to parse private key: %w", err)
+		}
+		fmt.Fprintf(os.Stderr, "Stored passphrase for %s is incorrect\n", keyPath)
+	}
+
+	for attempt := 1; attempt <= maxPassphraseAttempts; attempt++ {
+		fmt.Printf("Enter passphrase for key '%s': ", keyPath)
+		passphraseBytes, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Println() // New line after passphrase input
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read passphrase: %w", err)
+		}
+
+		signer, err := ssh.ParsePrivateKeyWithPassphrase(key, passphraseBytes)
+		if err == nil {
+			return signer, string(passphraseBytes), nil
+		}
+		if err != x509.IncorrectPasswordError {
+			return nil, "", fmt.Errorf("failed to parse private key: %w", err)
+		}
+		fmt.Fprintln(os.Stderr, "Incorrect passphrase, try again.")
+	}
+
+	return nil, "", fmt.Errorf("failed to decrypt private key %s: too many incorrect passphrases", keyPath)
+}
+
+// loadKeySigner loads a private key for authentication
+// Encrypted keys whose public key is known (embedded in the OpenSSH key
+// format or from the .pub file) are decrypted only when the server accepts
+// them, so the user is not asked for passphrases of keys that are never used.
+// onUnlock, if set, receives the passphrase once the key has been decrypted.
+func loadKeySigner(keyPath, passphrase string, onUnlock func(string)) (ssh.Signer, error) {
+	return openKeySigner(keyPath, passphrase, onUnlock, true)
+}
+
+// errPublicKeyUnknown is returned for encrypted default keys that cannot be
+// offered without decrypting them first
+var errPublicKeyUnknown = errors.New("encrypted key without public key")
+
+// loadDefaultKeySigner loads a key found in ~/.ssh like loadKeySigner, but
+// returns errPublicKeyUnknown instead of asking for the passphrase of an
+// encrypted key whose public key is unknown: default keys are best effort
+// and must not prompt before another method had a chance to authenticate.
+func loadDefaultKeySigner(keyPath string) (ssh.Signer, error) {
+	return openKeySigner(keyPath, "", nil, false)
+}
+
+// openKeySigner implements loadKeySigner and loadDefaultKeySigner; with
+// unlockUnknown set an encrypted key without known public key is decrypted
+// right away
+func openKeySigner(keyPath, passphrase string, onUnlock func(string), unlockUnknown bool) (ssh.Signer, error) {
+	key, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %w", err)
+	}
+
+	signer, err := ssh.ParsePrivateKey(key)
+	if err == nil {
+		return signer, nil
+	}
+
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
+	}
+
+	lazy := &lazyKeySigner{
+		path:       keyPath,
+		pub:        missing.PublicKey,
+		passphrase: passphrase,
+		onUnlock:   onUnlock,
+	}
+
+	if lazy.pub == nil {
+		if data, err := os.ReadFile(keyPath + ".pub"); err == nil {
+			lazy.pub, _, _, _, _ = ssh.ParseAuthorizedKey(data)
+		}
+	}
+
+	// Without a public key the key has to be decrypted right away
+	if lazy.pub == nil {
+		if !unlockUnknown {
+			return nil, errPublicKeyUnknown
+		}
+		if _, err := lazy.unlock(); err != nil {
+			return nil, err
+		}
+		lazy.pub = lazy.signer.PublicKey()
+	}
+
+	return lazy, nil
+}
+
+// lazyKeySigner defers decrypting a passphrase-protected key until a
+// signature is needed
+type lazyKeySigner struct {
+	path       string
+	pub        ssh.PublicKey
+	passphrase string
+	onUnlock   func(string)
+	signer     ssh.Signer
+}
+
+func (s *lazyKeySigner) PublicKey() ssh.PublicKey {
+	return s.pub
+}
+
+// unlock decrypts the key, prompting for the passphrase if needed
+func (s *lazyKeySigner) unlock() (ssh.Signer, error) {
+	if s.signer != nil {
+		return s.signer, nil
+	}
+
+	signer, passphrase, err := ParsePrivateKeyFile(s.path, s.passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	s.signer = signer
+	if s.onUnlock != nil {
+		s.onUnlock(passphrase)
+	}
+	return signer, nil
+}
+
+func (s *lazyKeySigner) Sign(rand io.Reader, data []byte) (*ssh.Signature, error) {
+	signer, err := s.unlock()
+	if err != nil {
+		return nil, err
+	}
+	return signer.Sign(rand, data)
+}
+
+func (s *lazyKeySigner) SignWithAlgorithm(rand io.Reader, data []byte, algorithm string) (*ssh.Signature, error) {
+	signer, err := s.unlock()
+	if err != nil {
+		return nil, err
+	}
+	if as, ok := signer.(ssh.AlgorithmSigner); ok {
+		return as.SignWithAlgorithm(rand, data, algorithm)
+	}
+	return signer.Sign(rand, data)
+}
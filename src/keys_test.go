@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// writeEncryptedPEMKey writes a passphrase-protected key in the legacy PEM
+// format, which, unlike the OpenSSH format, does not carry the public key
+func writeEncryptedPEMKey(t *testing.T, path, passphrase string) ssh.PublicKey {
+	t.Helper()
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := x509.EncryptPEMBlock(rand.Reader, "EC PRIVATE KEY", der, []byte(passphrase), x509.PEMCipherAES128)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pub, err := ssh.NewPublicKey(&priv.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub
+}
+
+func TestLoadDefaultKeySignerSkipsUnknownPublicKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "id_ecdsa")
+	writeEncryptedPEMKey(t, path, "secret")
+
+	// Decrypting would prompt on the terminal, which must not happen
+	if _, err := loadDefaultKeySigner(path); !errors.Is(err, errPublicKeyUnknown) {
+		t.Fatalf("loadDefaultKeySigner error = %v, want errPublicKeyUnknown", err)
+	}
+}
+
+func TestLoadDefaultKeySignerUsesPubFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "id_ecdsa")
+	pub := writeEncryptedPEMKey(t, path, "secret")
+	if err := os.WriteFile(path+".pub", ssh.MarshalAuthorizedKey(pub), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := loadDefaultKeySigner(path)
+	if err != nil {
+		t.Fatalf("loadDefaultKeySigner error: %v", err)
+	}
+	if _, ok := signer.(*lazyKeySigner); !ok {
+		t.Fatalf("signer is %T, want a key decrypted on first use", signer)
+	}
+	if ssh.FingerprintSHA256(signer.PublicKey()) != ssh.FingerprintSHA256(pub) {
+		t.Error("signer does not use the public key from the .pub file")
+	}
+}
+
+func TestLoadKeySignerUsesStoredPassphrase(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "id_ecdsa")
+	pub := writeEncryptedPEMKey(t, path, "secret")
+
+	var unlocked string
+	signer, err := loadKeySigner(path, "secret", func(used string) { unlocked = used })
+	if err != nil {
+		t.Fatalf("loadKeySigner error: %v", err)
+	}
+	if unlocked != "secret" {
+		t.Errorf("onUnlock got %q, want the stored passphrase", unlocked)
+	}
+	if ssh.FingerprintSHA256(signer.PublicKey()) != ssh.FingerprintSHA256(pub) {
+		t.Error("signer has the wrong public key")
+	}
+}
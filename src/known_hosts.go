@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Markers that can prefix a known_hosts line
+const (
+	markerCertAuthority = "@cert-authority"
+	markerRevoked       = "@revoked"
+)
+
+// knownHostsLine is a parsed line of a known_hosts file
+type knownHostsLine struct {
+	Marker   string   // "", "@cert-authority" or "@revoked"
+	Patterns []string // Host patterns, possibly hashed (|1|salt|hash)
+	Key      ssh.PublicKey
+	Comment  string
+	LineNum  int
+}
+
+// readKnownHosts parses a known_hosts file, skipping comments and lines
+// that cannot be parsed
+func readKnownHosts(knownHostsPath string) ([]knownHostsLine, error) {
+	file, err := os.Open(knownHostsPath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []knownHostsLine
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		if line, ok := parseKnownHostsLine(scanner.Text()); ok {
+			line.LineNum = lineNum
+			lines = append(lines, line)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading known_hosts: %w", err)
+	}
+	return lines, nil
+}
+
+// parseKnownHostsLine parses a single known_hosts line
+func parseKnownHostsLine(text string) (knownHostsLine, bool) {
+	text = strings.TrimSpace(text)
+	if text == "" || strings.HasPrefix(text, "#") {
+		return knownHostsLine{}, false
+	}
+
+	var line knownHostsLine
+	if strings.HasPrefix(text, "@") {
+		fields := strings.Fields(text)
+		line.Marker = fields[0]
+		text = strings.TrimSpace(strings.TrimPrefix(text, line.Marker))
+	}
+
+	fields := strings.Fields(text)
+	if len(fields) < 3 {
+		return knownHostsLine{}, false
+	}
+	line.Patterns = strings.Split(fields[0], ",")
+
+	key, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(strings.Join(fields[1:], " ")))
+	if err != nil {
+		return knownHostsLine{}, false
+	}
+	line.Key = key
+	line.Comment = comment
+
+	return line, true
+}
+
+// matches reports whether the line applies to host:port, following OpenSSH
+// rules: non-22 ports use the [host]:port form, patterns may contain * and ?
+// wildcards, hashed entries are compared by HMAC and a matching !pattern
+// excludes the host
+func (l knownHostsLine) matches(host, port string) bool {
+	target := knownHostsAddress(host, port)
+	matched := false
+
+	for _, pattern := range l.Patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+
+		var ok bool
+		if strings.HasPrefix(pattern, "|1|") {
+			ok = matchHashedHost(pattern, target)
+		} else {
+			ok = wildcardMatch(pattern, target)
+		}
+
+		if ok && negated {
+			return false
+		}
+		if ok {
+			matched = true
+		}
+	}
+
+	return matched
+}
+
+// knownHostsAddress returns the form used for a host in known_hosts:
+// host for port 22, [host]:port otherwise
+func knownHostsAddress(host, port string) string {
+	if port == "" || port == "22" {
+		return host
+	}
+	return "[" + host + "]:" + port
+}
+
+// splitHostPort splits the hostname passed to a HostKeyCallback into
+// host and port, defaulting to port 22
+func splitHostPort(hostname string) (string, string) {
+	host, port, err := net.SplitHostPort(hostname)
+	if err != nil {
+		return hostname, "22"
+	}
+	return host, port
+}
+
+// matchHashedHost compares a |1|salt|hash entry with a host
+func matchHashedHost(entry, host string) bool {
+	parts := strings.Split(entry, "|")
+	if len(parts) != 4 || parts[1] != "1" {
+		return false
+	}
+
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+// wildcardMatch matches s against a pattern where * matches any sequence of
+// characters and ? matches a single character (case-insensitive, like OpenSSH)
+func wildcardMatch(pattern, s string) bool {
+	return wildcardMatchCase(strings.ToLower(pattern), strings.ToLower(s))
+}
+
+// wildcardMatchCase is wildcardMatch for case-sensitive names, such as
+// environment variables
+func wildcardMatchCase(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			// Try every possible length for the * match
+			for i := 0; i <= len(s); i++ {
+				if wildcardMatchCase(pattern[1:], s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || pattern[0] != s[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+
+	return len(s) == 0
+}
+
+// keysEqual reports whether two public keys are identical
+func keysEqual(a, b ssh.PublicKey) bool {
+	return bytes.Equal(a.Marshal(), b.Marshal())
+}
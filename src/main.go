@@ -1,19 +1,71 @@
 package main
 
 import (
-	"bufio"
+	"cmp"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
-
-	"golang.org/x/term"
+	"time"
 )
 
 const (
 	version = "1.2.1"
 )
 
+// exitConnectionLost is the exit status when keepalives detect a dead
+// connection, the status ssh uses for connection errors
+const exitConnectionLost = 255
+
+// stringList is a flag.Value that collects repeated flag values
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// splitArgs separates flag arguments from remote command arguments.
+// Everything from the first non-flag argument onwards belongs to the command.
+func splitArgs(args []string) (flagArgs, cmdArgs []string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			return flagArgs, args[i:]
+		}
+
+		flagArgs = append(flagArgs, arg)
+		if flagTakesValue(arg) && i+1 < len(args) {
+			i++
+			flagArgs = append(flagArgs, args[i])
+		}
+	}
+	return flagArgs, nil
+}
+
+// flagTakesValue reports whether a flag argument consumes the next argument
+func flagTakesValue(arg string) bool {
+	name := strings.TrimLeft(arg, "-")
+	if strings.Contains(name, "=") {
+		return false
+	}
+
+	f := flag.Lookup(name)
+	if f == nil {
+		return false
+	}
+	if bf, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && bf.IsBoolFlag() {
+		return false
+	}
+	return true
+}
+
 // parseUserHost parses "user@host" format and returns user, host
 func parseUserHost(arg string) (user, host string, ok bool) {
 	parts := strings.Split(arg, "@")
@@ -23,6 +75,18 @@ func parseUserHost(arg string) (user, host string, ok bool) {
 	return "", "", false
 }
 
+// parseOption splits a -o option given as Key=Value or "Key Value"
+func parseOption(option string) (key, value string, err error) {
+	option = strings.TrimSpace(option)
+	if key, value, ok := strings.Cut(option, "="); ok {
+		return strings.TrimSpace(key), strings.TrimSpace(value), nil
+	}
+	if key, value, ok := strings.Cut(option, " "); ok {
+		return key, strings.TrimSpace(value), nil
+	}
+	return "", "", fmt.Errorf("invalid option %q (expected Key=Value)", option)
+}
+
 func main() {
 	// Command line flags
 	host := flag.String("host", "", "SSH server hostname or IP address")
@@ -30,9 +94,37 @@ func main() {
 	user := flag.String("user", "", "SSH username")
 	password := flag.String("password", "", "SSH password (not recommended, use -key instead)")
 	keyPath := flag.String("key", "", "Path to SSH private key file")
+	certPath := flag.String("cert", "", "Path to SSH user certificate (default: <key>-cert.pub)")
 	cmd := flag.String("cmd", "", "Command to execute on remote server")
 	interactive := flag.Bool("i", false, "Start interactive shell session")
 	showVersion := flag.Bool("version", false, "Show version information")
+	noCommand := flag.Bool("N", false, "Do not execute a remote command (port forwarding only)")
+	var localForwards stringList
+	flag.Var(&localForwards, "L", "Local port forward [bind_address:]port:host:hostport (repeatable)")
+	var remoteForwards stringList
+	flag.Var(&remoteForwards, "R", "Remote port forward [bind_address:]port:host:hostport, * binds all IPv4 addresses (repeatable)")
+	var dynamicForwards stringList
+	flag.Var(&dynamicForwards, "D", "Dynamic SOCKS5 proxy [bind_address:]port (repeatable)")
+	socksUser := flag.String("socks-user", "", "Username required by the local SOCKS5 proxy (password from $"+socksPasswordEnv+" or prompted)")
+	jumpHosts := flag.String("J", "", "Jump hosts, comma separated (@profile or [user@]host[:port]), replaces the profile's proxy command")
+	forwardAgent := flag.Bool("A", false, "Forward the SSH agent to the remote host")
+	proxyCommand := flag.String("proxy-command", "", "Command to use as transport (%h, %p, %r, %n are expanded), replaces the profile's jump hosts, not combinable with -J")
+	connectTimeout := flag.Int("connect-timeout", 0, "Seconds to wait for the connection, 0 for the default of 10 (ConnectTimeout)")
+	ipv4Only := flag.Bool("4", false, "Connect using IPv4 addresses only")
+	ipv6Only := flag.Bool("6", false, "Connect using IPv6 addresses only")
+	bindAddress := flag.String("b", "", "Local address to connect from (BindAddress)")
+	bindInterface := flag.String("B", "", "Local interface whose address is used to connect from (BindInterface)")
+	keepAlive := flag.Int("keepalive", 0, "Seconds between keepalive messages, 0 disables them (ServerAliveInterval)")
+	reconnect := flag.Bool("reconnect", false, "Reconnect with backoff when the connection drops")
+	reconnectAttempts := flag.Int("reconnect-attempts", 0, "Reconnect attempts per outage before giving up, 0 for no limit")
+	reconnectDuration := flag.Int("reconnect-duration", 0, "Seconds per outage before giving up reconnecting, 0 for no limit")
+	reattach := flag.String("reattach", "", "Interactive session after reconnecting: shell, tmux[:name] or screen[:name]")
+	ciphers := flag.String("c", "", "Ciphers in order of preference, +, - or ^ modify the defaults (Ciphers)")
+	macs := flag.String("m", "", "MAC algorithms in order of preference, +, - or ^ modify the defaults (MACs)")
+	kexAlgorithms := flag.String("kex", "", "Key exchange algorithms in order of preference, +, - or ^ modify the defaults (KexAlgorithms)")
+	hostKeyAlgorithms := flag.String("host-key-algorithms", "", "Host key algorithms in order of preference, +, - or ^ modify the defaults (HostKeyAlgorithms)")
+	var options stringList
+	flag.Var(&options, "o", "Option in Key=Value form, e.g. StrictHostKeyChecking=accept-new (repeatable)")
 
 	// Check for profile command
 	if len(os.Args) > 1 && os.Args[1] == "profile" {
@@ -43,10 +135,62 @@ func main() {
 		os.Exit(0)
 	}
 
+	// Check for hostkey command
+	if len(os.Args) > 1 && os.Args[1] == "hostkey" {
+		if err := HandleHostKeyCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Check for algorithms command
+	if len(os.Args) > 1 && os.Args[1] == "algorithms" {
+		if err := HandleAlgorithmsCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Check for control command
+	if len(os.Args) > 1 && os.Args[1] == "control" {
+		if err := HandleControlCommand(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	// Arguments as given, for starting a control master
+	originalArgs := os.Args[1:]
+
+	// Host name as given by the user (%n in ProxyCommand)
+	var alias string
+
+	// Saved profile (nil when not connecting with @profile), its key passphrase
+	// and authentication order
+	var profile *Profile
+	var passphrase, socksPassword string
+	var authOrder []string
+	var hostKeys HostKeyOptions
+
+	// Settings only available through profiles and -o
+	var identityFiles, sendEnv, setEnv []string
+	var identitiesOnly, compression bool
+	var addressFamily string
+	var keepAliveCountMax int
+	var controlMaster bool
+	var controlPersist string
+	var requestTTY string
+	var algorithms AlgorithmOptions
+
 	// Check for @profile format
 	if len(os.Args) > 1 && strings.HasPrefix(os.Args[1], "@") {
 		profileName := strings.TrimPrefix(os.Args[1], "@")
-		profile, err := FindProfile(profileName)
+		alias = profileName
+		var err error
+		profile, err = FindProfile(profileName)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
@@ -61,77 +205,89 @@ func main() {
 		if profile.Key != "" {
 			*keyPath = profile.Key
 		}
+		*certPath = profile.Certificate
+		hostKeys.HostCAs = profile.HostCA
+		hostKeys.StrictHostKeyChecking = profile.StrictHostKeyChecking
+		hostKeys.HashKnownHosts = profile.HashKnownHosts
+		hostKeys.UpdateHostKeys = profile.UpdateHostKeys
+		hostKeys.Fingerprints = profile.HostKeyFingerprints
+		hostKeys.KnownHostsFile = profile.KnownHostsFile
+		identityFiles = profile.IdentityFiles
+		identitiesOnly = profile.IdentitiesOnly
+		*connectTimeout = profile.ConnectTimeout
+		addressFamily = profile.AddressFamily
+		*bindAddress = profile.BindAddress
+		*bindInterface = profile.BindInterface
+		*keepAlive = profile.ServerAliveInterval
+		keepAliveCountMax = profile.ServerAliveCountMax
+		*reconnect = profile.Reconnect
+		*reconnectAttempts = profile.ReconnectMaxAttempts
+		*reconnectDuration = profile.ReconnectMaxDuration
+		*reattach = profile.Reattach
+		controlMaster = profile.ControlMaster
+		controlPersist = profile.ControlPersist
+		sendEnv = profile.SendEnv
+		setEnv = profile.SetEnv
+		requestTTY = profile.RequestTTY
+		compression = profile.Compression
+		algorithms = AlgorithmOptions{
+			Ciphers:           profile.Ciphers,
+			KexAlgorithms:     profile.KexAlgorithms,
+			MACs:              profile.MACs,
+			HostKeyAlgorithms: profile.HostKeyAlgorithms,
+			Lenient:           profile.FromSSHConfig,
+		}
 
 		// Handle password (encrypted or plain text)
-		if profile.EncryptedPassword != "" {
-			// Decrypt the password automatically
-			decrypted, err := DecryptAuto(profile.EncryptedPassword)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Error decrypting password: %v\n", err)
-				os.Exit(1)
-			}
-			*password = decrypted
-		} else if profile.Password != "" {
-			// Legacy plain text password
-			*password = profile.Password
+		decrypted, err := profile.GetPassword()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error decrypting password: %v\n", err)
+			os.Exit(1)
 		}
+		*password = decrypted
 
-		// Process remaining arguments
-		remainingArgs := os.Args[2:]
-		newArgs := []string{os.Args[0]}
-		cmdArgs := []string{}
-
-		for i := 0; i < len(remainingArgs); i++ {
-			arg := remainingArgs[i]
-
-			if strings.HasPrefix(arg, "-") {
-				newArgs = append(newArgs, arg)
-				if i+1 < len(remainingArgs) && !strings.HasPrefix(remainingArgs[i+1], "-") {
-					i++
-					newArgs = append(newArgs, remainingArgs[i])
-				}
-			} else {
-				cmdArgs = append(cmdArgs, arg)
-			}
+		passphrase, err = profile.GetPassphrase()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error decrypting key passphrase: %v\n", err)
+			os.Exit(1)
+		}
+		authOrder = profile.AuthOrder
+		*jumpHosts = strings.Join(profile.Jump, ",")
+		*proxyCommand = profile.ProxyCommand
+		*forwardAgent = profile.ForwardAgent
+
+		localForwards = append(localForwards, profile.Forwards...)
+		remoteForwards = append(remoteForwards, profile.RemoteForwards...)
+		dynamicForwards = append(dynamicForwards, profile.DynamicForwards...)
+		*socksUser = profile.SocksUser
+		socksPassword, err = profile.GetSocksPassword()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error decrypting SOCKS password: %v\n", err)
+			os.Exit(1)
 		}
 
+		// Process remaining arguments
+		flagArgs, cmdArgs := splitArgs(os.Args[2:])
+
 		if len(cmdArgs) > 0 {
 			*cmd = strings.Join(cmdArgs, " ")
+		} else if profile.RemoteCommand != "" {
+			*cmd = profile.RemoteCommand
 		} else {
 			*interactive = true
 		}
 
-		os.Args = newArgs
+		os.Args = append([]string{os.Args[0]}, flagArgs...)
 	} else if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
 		// Parse traditional SSH format: user@host [command...]
 		if u, h, ok := parseUserHost(os.Args[1]); ok {
 			// Found user@host format
 			*user = u
 			*host = h
+			alias = h
 
-			// Process remaining arguments
-			remainingArgs := os.Args[2:]
-
-			// Reconstruct os.Args for flag parsing
-			newArgs := []string{os.Args[0]}
-			cmdArgs := []string{}
-
-			for i := 0; i < len(remainingArgs); i++ {
-				arg := remainingArgs[i]
-
-				// Check if it's a flag
-				if strings.HasPrefix(arg, "-") {
-					newArgs = append(newArgs, arg)
-					// If flag has a value, add it too
-					if i+1 < len(remainingArgs) && !strings.HasPrefix(remainingArgs[i+1], "-") {
-						i++
-						newArgs = append(newArgs, remainingArgs[i])
-					}
-				} else {
-					// It's a command argument
-					cmdArgs = append(cmdArgs, arg)
-				}
-			}
+			// Separate flags from the remote command
+			flagArgs, cmdArgs := splitArgs(os.Args[2:])
 
 			// If there are command arguments, join them
 			if len(cmdArgs) > 0 {
@@ -142,7 +298,7 @@ func main() {
 			}
 
 			// Update os.Args for flag parsing
-			os.Args = newArgs
+			os.Args = append([]string{os.Args[0]}, flagArgs...)
 		}
 	}
 
@@ -153,7 +309,10 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  sshclient @profile [command...]          # Use saved profile\n")
 		fmt.Fprintf(os.Stderr, "  sshclient user@host [command...]         # Traditional SSH style\n")
 		fmt.Fprintf(os.Stderr, "  sshclient [flags]                        # Flag-based style\n")
-		fmt.Fprintf(os.Stderr, "  sshclient profile <command> [args]       # Manage profiles\n\n")
+		fmt.Fprintf(os.Stderr, "  sshclient profile <command> [args]       # Manage profiles\n")
+		fmt.Fprintf(os.Stderr, "  sshclient hostkey <command> [args]       # Manage known host keys\n")
+		fmt.Fprintf(os.Stderr, "  sshclient control <command> [args]       # Manage shared connections\n")
+		fmt.Fprintf(os.Stderr, "  sshclient algorithms [kind]              # List supported algorithms\n\n")
 		fmt.Fprintf(os.Stderr, "Flags:\n")
 		flag.PrintDefaults()
 		fmt.Fprintf(os.Stderr, "\nExamples:\n")
@@ -167,6 +326,13 @@ func main() {
 		fmt.Fprintf(os.Stderr, "  # Flag style\n")
 		fmt.Fprintf(os.Stderr, "  sshclient -host example.com -user myuser -i\n")
 		fmt.Fprintf(os.Stderr, "  sshclient -host example.com -user myuser -cmd \"uptime\"\n\n")
+		fmt.Fprintf(os.Stderr, "  # Port forwarding\n")
+		fmt.Fprintf(os.Stderr, "  sshclient @db -L 5432:localhost:5432          # With shell\n")
+		fmt.Fprintf(os.Stderr, "  sshclient @db -N -L 5432:localhost:5432       # Tunnel only\n")
+		fmt.Fprintf(os.Stderr, "  sshclient @staging -R 8080:localhost:3000     # Expose local port\n")
+		fmt.Fprintf(os.Stderr, "  sshclient @bastion -N -D 1080                 # SOCKS5 proxy\n\n")
+		fmt.Fprintf(os.Stderr, "  # Jump hosts\n")
+		fmt.Fprintf(os.Stderr, "  sshclient user@internal -J @bastion1,@bastion2\n\n")
 		fmt.Fprintf(os.Stderr, "  # Profile management\n")
 		fmt.Fprintf(os.Stderr, "  sshclient profile add myserver\n")
 		fmt.Fprintf(os.Stderr, "  sshclient profile list\n\n")
@@ -175,6 +341,83 @@ func main() {
 
 	flag.Parse()
 
+	// Options given with -o override the profile
+	for _, option := range options {
+		key, value, err := parseOption(option)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		switch strings.ToLower(key) {
+		case "stricthostkeychecking":
+			hostKeys.StrictHostKeyChecking = value
+		case "hashknownhosts":
+			hostKeys.HashKnownHosts = strings.ToLower(value) == "yes"
+		case "updatehostkeys":
+			hostKeys.UpdateHostKeys = value
+		case "userknownhostsfile":
+			hostKeys.KnownHostsFile = value
+		case "identitiesonly":
+			identitiesOnly = strings.ToLower(value) == "yes"
+		case "connecttimeout":
+			*connectTimeout, err = strconv.Atoi(value)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: invalid ConnectTimeout %q\n", value)
+				os.Exit(1)
+			}
+		case "addressfamily":
+			addressFamily = value
+		case "bindaddress":
+			*bindAddress = value
+		case "bindinterface":
+			*bindInterface = value
+		case "serveraliveinterval":
+			*keepAlive, err = strconv.Atoi(value)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: invalid ServerAliveInterval %q\n", value)
+				os.Exit(1)
+			}
+		case "serveralivecountmax":
+			keepAliveCountMax, err = strconv.Atoi(value)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: invalid ServerAliveCountMax %q\n", value)
+				os.Exit(1)
+			}
+		case "controlmaster":
+			controlMaster = isYes(value) || strings.ToLower(value) == "auto"
+		case "controlpersist":
+			controlPersist = value
+		case "requesttty":
+			requestTTY = value
+		case "ciphers":
+			algorithms.Ciphers = value
+			algorithms.Lenient = false
+		case "kexalgorithms":
+			algorithms.KexAlgorithms = value
+			algorithms.Lenient = false
+		case "macs":
+			algorithms.MACs = value
+			algorithms.Lenient = false
+		case "hostkeyalgorithms":
+			algorithms.HostKeyAlgorithms = value
+			algorithms.Lenient = false
+		default:
+			fmt.Fprintf(os.Stderr, "Error: unsupported option %q\n", key)
+			os.Exit(1)
+		}
+	}
+
+	// Algorithm flags override both the profile and -o
+	// Names typed on the command line must all be known, even when the
+	// rest of the lists come from ~/.ssh/config.
+	if *ciphers != "" || *macs != "" || *kexAlgorithms != "" || *hostKeyAlgorithms != "" {
+		algorithms.Lenient = false
+	}
+	algorithms.Ciphers = cmp.Or(*ciphers, algorithms.Ciphers)
+	algorithms.MACs = cmp.Or(*macs, algorithms.MACs)
+	algorithms.KexAlgorithms = cmp.Or(*kexAlgorithms, algorithms.KexAlgorithms)
+	algorithms.HostKeyAlgorithms = cmp.Or(*hostKeyAlgorithms, algorithms.HostKeyAlgorithms)
+
 	// Show version
 	if *showVersion {
 		fmt.Printf("SSH Client v%s\n", version)
@@ -217,52 +460,142 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Determine authentication method
-	var client *SSHClient
-	var err error
+	requestTTY, err := ParseRequestTTY(requestTTY)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
 
-	if *keyPath != "" {
-		// Key-based authentication (explicit key path)
-		fmt.Printf("Connecting to %s@%s:%s using key authentication...\n", *user, *host, *port)
-		client, err = NewSSHClientWithKey(*host, *port, *user, *keyPath)
+	// A reattach command replaces the login shell from the first session on
+	sessionCommand, restartShell, err := ParseReattach(*reattach)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	reconnectOpts := ReconnectOptions{
+		MaxAttempts: *reconnectAttempts,
+		MaxDuration: time.Duration(*reconnectDuration) * time.Second,
+	}
+	if *reconnect && *keepAlive == 0 {
+		*keepAlive = int(DefaultReconnectKeepAlive / time.Second)
+	}
+	// -4 and -6 override the address family from the profile and -o
+	if *ipv4Only && *ipv6Only {
+		fmt.Fprintln(os.Stderr, "Error: -4 and -6 cannot be used together")
+		os.Exit(1)
+	}
+	if *ipv4Only {
+		addressFamily = AddressFamilyInet
+	} else if *ipv6Only {
+		addressFamily = AddressFamilyInet6
+	}
+	dialOpts := DialOptions{
+		AddressFamily: addressFamily,
+		BindAddress:   *bindAddress,
+		BindInterface: *bindInterface,
+	}
+	var persist time.Duration
+	if controlMaster {
+		persist, err = ParseControlPersist(controlPersist)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to create SSH client: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 			os.Exit(1)
 		}
-	} else if *password != "" {
-		// Password authentication (from command line)
-		fmt.Printf("Connecting to %s@%s:%s using password authentication...\n", *user, *host, *port)
-		client, err = NewSSHClient(*host, *port, *user, *password)
+	}
+
+	// Both replace the direct TCP connection, so only one of them can be
+	// used; as in OpenSSH one given on the command line replaces the
+	// profile's transport
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	switch {
+	case given["J"] && given["proxy-command"]:
+	case given["J"]:
+		*proxyCommand = ""
+	case given["proxy-command"]:
+		*jumpHosts = ""
+	}
+	if *jumpHosts != "" && *proxyCommand != "" {
+		fmt.Fprintln(os.Stderr, "Error: jump hosts and a proxy command cannot be used together")
+		os.Exit(1)
+	}
+
+	// Create client for the target host
+	client, err := newClient(*host, *port, *user, AuthOptions{
+		Order:          authOrder,
+		KeyPath:        *keyPath,
+		Passphrase:     passphrase,
+		Certificate:    *certPath,
+		Password:       *password,
+		IdentityFiles:  identityFiles,
+		IdentitiesOnly: identitiesOnly,
+	}, hostKeys)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create SSH client: %v\n", err)
+		os.Exit(1)
+	}
+	if err := client.SetAlgorithms(algorithms); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	client.SetConnectTimeout(time.Duration(*connectTimeout) * time.Second)
+	if err := client.SetDialOptions(dialOpts); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	client.SetEnv(SessionEnv(sendEnv, setEnv))
+	client.SetKeepAlive(time.Duration(*keepAlive)*time.Second, keepAliveCountMax)
+	if compression {
+		fmt.Fprintln(os.Stderr, "Warning: compression is not supported, connecting without it")
+	}
+
+	// Route the connection through jump hosts if requested
+	if hops := ParseJumpHosts(*jumpHosts); len(hops) > 0 {
+		jump, err := newJumpChain(hops, hostKeys, dialOpts, time.Duration(*connectTimeout)*time.Second)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to create SSH client: %v\n", err)
+			fmt.Fprintf(os.Stderr, "Failed to set up jump hosts: %v\n", err)
 			os.Exit(1)
 		}
-	} else {
-		// Try default SSH key first
-		defaultKey := GetDefaultKeyPath()
-		if defaultKey != "" {
-			fmt.Printf("Trying default SSH key: %s\n", defaultKey)
-			client, err = NewSSHClientWithKey(*host, *port, *user, defaultKey)
-			if err == nil {
-				fmt.Printf("Using key authentication with %s\n", defaultKey)
-			}
+		client.SetJump(jump)
+	}
+
+	// Forward the agent only when explicitly requested
+	if *forwardAgent {
+		if err := client.EnableAgentForwarding(); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
 		}
+	}
 
-		// If no default key or key auth failed, prompt for password
-		if client == nil {
-			fmt.Printf("Password for %s@%s: ", *user, *host)
-			passwordBytes, err := term.ReadPassword(int(os.Stdin.Fd()))
-			fmt.Println() // New line after password input
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to read password: %v\n", err)
-				os.Exit(1)
-			}
-			client, err = NewSSHClient(*host, *port, *user, string(passwordBytes))
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "Failed to create SSH client: %v\n", err)
-				os.Exit(1)
+	// Use a proxy command as transport if configured
+	if *proxyCommand != "" {
+		if alias == "" {
+			alias = *host
+		}
+		client.SetProxyCommand(ExpandProxyCommand(*proxyCommand, *host, *port, *user, alias))
+	}
+
+	// Share one connection between invocations through a control master
+	// (remote forwards are not multiplexed, they need their own connection)
+	controlPath, isControlMaster := IsControlMaster()
+	if controlMaster && !isControlMaster && len(remoteForwards) == 0 {
+		path, err := ControlSocketPath(*user, *host, *port)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		// Only use the socket when a master is actually serving it
+		_, err = ControlMasterPid(path)
+		running := err == nil
+		if !running {
+			if err := StartControlMaster(path, originalArgs); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: %v, connecting directly\n", err)
+			} else {
+				running = true
 			}
 		}
+		if running {
+			client.SetControlPath(path)
+		}
 	}
 
 	// Connect to server
@@ -273,14 +606,121 @@ func main() {
 	defer client.Close()
 
 	fmt.Println("Connected successfully!")
+	fmt.Printf("Authenticated using %s\n", client.AuthMethod())
+
+	// Offer to remember a newly entered key passphrase in the profile
+	if profile != nil && client.KeyPassphrase() != "" && client.KeyPassphrase() != passphrase {
+		offerSavePassphrase(profile.Name, client.KeyPassphrase())
+	}
+
+	// Started by StartControlMaster: serve the socket instead of a session
+	if isControlMaster {
+		if err := client.ServeControl(controlPath, persist); err != nil {
+			fmt.Fprintf(os.Stderr, "Control master failed: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Start port forwards
+	for _, spec := range localForwards {
+		fwd, err := ParseLocalForward(spec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		if err := client.StartLocalForward(fwd); err != nil {
+			fmt.Fprintf(os.Stderr, "Port forwarding failed: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Forwarding %s\n", fwd)
+	}
+	for _, spec := range remoteForwards {
+		fwd, err := ParseRemoteForward(spec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		addr, err := client.StartRemoteForward(fwd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Port forwarding failed: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Forwarding remote %s -> %s\n", addr, fwd.Target)
+	}
+	if len(dynamicForwards) > 0 && *socksUser != "" {
+		// The password is never taken from the command line, where other
+		// users could see it
+		prompted := false
+		if env := os.Getenv(socksPasswordEnv); env != "" {
+			socksPassword = env
+		} else if socksPassword == "" {
+			socksPassword, err = promptSocksPassword(*socksUser)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			prompted = true
+		}
+		if prompted && profile != nil {
+			offerSaveSocksPassword(profile.Name, socksPassword)
+		}
+	}
+	for _, spec := range dynamicForwards {
+		fwd, err := ParseDynamicForward(spec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		fwd.Username = *socksUser
+		fwd.Password = socksPassword
+		addr, err := client.StartDynamicForward(fwd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Port forwarding failed: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("SOCKS5 proxy listening on %s\n", addr)
+	}
 
 	// Execute command or start interactive shell
-	if *interactive {
+	hasForwards := len(localForwards) > 0 || len(remoteForwards) > 0 || len(dynamicForwards) > 0
+	if *noCommand || (!*interactive && *cmd == "" && hasForwards) {
+		// Forwarding only: keep the connection open until it is closed
+		fmt.Println("Port forwarding active. Press Ctrl+C to stop.")
+		var err error
+		if *reconnect {
+			err = client.Supervise(reconnectOpts, client.Wait, true)
+		} else {
+			err = client.Wait()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Connection closed: %v\n", err)
+			os.Exit(exitStatus(err))
+		}
+	} else if *interactive {
 		// Interactive shell
 		fmt.Println("Starting interactive shell... (Press Ctrl+D or type 'exit' to quit)")
-		if err := client.StartInteractiveShell(); err != nil {
+		shell := client.StartInteractiveShell
+		if sessionCommand != "" {
+			shell = func() error { return client.RunCommandWithTTY(sessionCommand) }
+		}
+
+		var err error
+		// Without a restart there is nothing to reconnect for unless forwards are active
+		if *reconnect && (restartShell || hasForwards) {
+			err = client.Supervise(reconnectOpts, shell, restartShell)
+		} else {
+			err = shell()
+		}
+		if err != nil {
 			fmt.Fprintf(os.Stderr, "Shell session failed: %v\n", err)
-			os.Exit(1)
+			os.Exit(exitStatus(err))
+		}
+	} else if *cmd != "" && commandWantsTTY(requestTTY) {
+		// Command that needs a terminal, like ssh -t
+		if err := client.RunCommandWithTTY(*cmd); err != nil {
+			fmt.Fprintf(os.Stderr, "Command failed: %v\n", err)
+			os.Exit(exitStatus(err))
 		}
 	} else if *cmd != "" {
 		// Execute single command
@@ -288,7 +728,7 @@ func main() {
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "Command failed: %v\n", err)
 			fmt.Print(output) // Print output even if command failed
-			os.Exit(1)
+			os.Exit(exitStatus(err))
 		}
 		fmt.Print(output)
 	} else {
@@ -313,12 +753,177 @@ func main() {
 	}
 }
 
+// newClient creates an SSH client for the given host
+// All authentication methods are tried in order during Connect
+func newClient(host, port, user string, auth AuthOptions, hostKeys HostKeyOptions) (*SSHClient, error) {
+	fmt.Printf("Connecting to %s@%s:%s...\n", user, host, port)
+
+	client, err := NewSSHClientWithAuth(host, port, user, auth)
+	if err != nil {
+		return nil, err
+	}
+	if err := client.SetHostKeyOptions(hostKeys); err != nil {
+		return nil, err
+	}
+
+	// Keys loaded in ssh-agent are offered at the "agent" position of the auth order
+	if ag, err := ConnectAgent(); err == nil {
+		client.SetAgent(ag)
+	}
+
+	return client, nil
+}
+
+// newJumpChain creates clients for each jump host, each one connecting
+// through the previous hop, and returns the last hop
+// Hops without their own StrictHostKeyChecking or UpdateHostKeys use the
+// target's setting, and the target's HashKnownHosts applies to every hop.
+// The same goes for the connect timeout, address family and bind address,
+// which only matter for the first hop.
+func newJumpChain(hops []string, hostKeys HostKeyOptions, dialOpts DialOptions, timeout time.Duration) (*SSHClient, error) {
+	var prev *SSHClient
+	for _, hop := range hops {
+		profile, err := ResolveJumpHost(hop)
+		if err != nil {
+			return nil, err
+		}
+
+		user := profile.User
+		port := profile.Port
+		if port == "" {
+			port = "22"
+		}
+		password, err := profile.GetPassword()
+		if err != nil {
+			return nil, fmt.Errorf("jump host %s: failed to decrypt password: %w", hop, err)
+		}
+		passphrase, err := profile.GetPassphrase()
+		if err != nil {
+			return nil, fmt.Errorf("jump host %s: failed to decrypt key passphrase: %w", hop, err)
+		}
+
+		client, err := newClient(profile.Host, port, user, AuthOptions{
+			Order:          profile.AuthOrder,
+			KeyPath:        profile.Key,
+			Passphrase:     passphrase,
+			Certificate:    profile.Certificate,
+			Password:       password,
+			IdentityFiles:  profile.IdentityFiles,
+			IdentitiesOnly: profile.IdentitiesOnly,
+		}, HostKeyOptions{
+			HostCAs:               profile.HostCA,
+			StrictHostKeyChecking: cmp.Or(profile.StrictHostKeyChecking, hostKeys.StrictHostKeyChecking),
+			HashKnownHosts:        profile.HashKnownHosts || hostKeys.HashKnownHosts,
+			UpdateHostKeys:        cmp.Or(profile.UpdateHostKeys, hostKeys.UpdateHostKeys),
+			Fingerprints:          profile.HostKeyFingerprints,
+			KnownHostsFile:        profile.KnownHostsFile,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("jump host %s: %w", hop, err)
+		}
+		err = client.SetAlgorithms(AlgorithmOptions{
+			Ciphers:           profile.Ciphers,
+			KexAlgorithms:     profile.KexAlgorithms,
+			MACs:              profile.MACs,
+			HostKeyAlgorithms: profile.HostKeyAlgorithms,
+			Lenient:           profile.FromSSHConfig,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("jump host %s: %w", hop, err)
+		}
+		if profile.ConnectTimeout > 0 {
+			client.SetConnectTimeout(time.Duration(profile.ConnectTimeout) * time.Second)
+		} else {
+			client.SetConnectTimeout(timeout)
+		}
+		hopDial := DialOptions{
+			AddressFamily: cmp.Or(profile.AddressFamily, dialOpts.AddressFamily),
+			BindAddress:   dialOpts.BindAddress,
+			BindInterface: dialOpts.BindInterface,
+		}
+		if profile.BindAddress != "" || profile.BindInterface != "" {
+			hopDial.BindAddress = profile.BindAddress
+			hopDial.BindInterface = profile.BindInterface
+		}
+		if err := client.SetDialOptions(hopDial); err != nil {
+			return nil, fmt.Errorf("jump host %s: %w", hop, err)
+		}
+		client.SetJump(prev)
+		prev = client
+	}
+	return prev, nil
+}
+
+// offerSavePassphrase asks whether to store the key passphrase encrypted in
+// a custom profile (profiles from ~/.ssh/config are read-only)
+func offerSavePassphrase(name, passphrase string) {
+	// Only custom profiles can store it
+	if _, err := GetProfile(name); err != nil {
+		return
+	}
+
+	if !promptYesNo("Save key passphrase to profile (encrypted)?") {
+		return
+	}
+
+	encrypted, err := EncryptAuto(passphrase)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encrypt passphrase: %v\n", err)
+		return
+	}
+
+	err = UpdateProfile(name, func(profile *Profile) {
+		profile.EncryptedPassphrase = encrypted
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save profile: %v\n", err)
+		return
+	}
+	fmt.Printf("✅ Passphrase saved to profile '%s'\n", name)
+}
+
 // Helper functions
 
+// exitStatus returns the exit status for a failed session
+func exitStatus(err error) int {
+	if errors.Is(err, ErrConnectionLost) {
+		return exitConnectionLost
+	}
+	return 1
+}
+
+// offerSaveSocksPassword asks whether to store the SOCKS5 proxy password
+// encrypted in a custom profile, replacing a plain text one
+func offerSaveSocksPassword(name, password string) {
+	// Only custom profiles can store it
+	if _, err := GetProfile(name); err != nil {
+		return
+	}
+
+	if !promptYesNo("Save SOCKS password to profile (encrypted)?") {
+		return
+	}
+
+	encrypted, err := EncryptAuto(password)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encrypt SOCKS password: %v\n", err)
+		return
+	}
+
+	err = UpdateProfile(name, func(profile *Profile) {
+		profile.EncryptedSocksPassword = encrypted
+		profile.SocksPassword = ""
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to save profile: %v\n", err)
+		return
+	}
+	fmt.Printf("✅ SOCKS password saved to profile '%s'\n", name)
+}
+
 func promptYesNo(prompt string) bool {
-	reader := bufio.NewReader(os.Stdin)
 	fmt.Printf("%s (y/n): ", prompt)
-	response, err := reader.ReadString('\n')
+	response, err := stdinReader.ReadString('\n')
 	if err != nil {
 		return false
 	}
@@ -2,11 +2,14 @@ package main
 
 import (
 	"bufio"
+	"cmp"
 	"fmt"
 	"os"
 	"sort"
 	"strings"
+	"time"
 
+	"golang.org/x/crypto/ssh"
 	"golang.org/x/term"
 )
 
@@ -41,16 +44,25 @@ func ProfileAdd(name string) error {
 		port = "22"
 	}
 
+	// Pin the host key fingerprint if the user wants to skip trust on first use
+	var fingerprints []string
+	fmt.Print("\nFetch and pin the server's host key fingerprint? (y/n): ")
+	pin, _ := reader.ReadString('\n')
+	if answer := strings.ToLower(strings.TrimSpace(pin)); answer == "y" || answer == "yes" {
+		fingerprints = fetchFingerprints(reader, host, port)
+	}
+
 	// Ask for authentication method
 	fmt.Print("\nAuthentication method (1: SSH key, 2: Password): ")
 	method, _ := reader.ReadString('\n')
 	method = strings.TrimSpace(method)
 
 	profile := Profile{
-		Name: name,
-		Host: host,
-		User: user,
-		Port: port,
+		Name:                name,
+		Host:                host,
+		User:                user,
+		Port:                port,
+		HostKeyFingerprints: fingerprints,
 	}
 
 	if method == "1" {
@@ -102,6 +114,35 @@ func ProfileAdd(name string) error {
 	return nil
 }
 
+// fetchFingerprints fetches the server's host keys and returns their
+// fingerprints if the user confirms them (nil otherwise)
+func fetchFingerprints(reader *bufio.Reader, host, port string) []string {
+	keys, err := ScanHostKeys(host, port, 10*time.Second)
+	if err != nil {
+		fmt.Printf("⚠️  Could not fetch host keys: %v\n", err)
+		return nil
+	}
+
+	fmt.Printf("\nHost keys offered by %s:\n", knownHostsAddress(host, port))
+	for _, key := range keys {
+		printFingerprints(key, "  ")
+	}
+	fmt.Println("\nCompare these with the fingerprints obtained from the server administrator.")
+	fmt.Print("Pin these fingerprints? (y/n): ")
+	confirm, _ := reader.ReadString('\n')
+	if answer := strings.ToLower(strings.TrimSpace(confirm)); answer != "y" && answer != "yes" {
+		fmt.Println("Fingerprints not pinned")
+		return nil
+	}
+
+	fingerprints := make([]string, len(keys))
+	for i, key := range keys {
+		fingerprints[i] = ssh.FingerprintSHA256(key)
+	}
+	fmt.Printf("✅ Pinned %d fingerprint(s)\n", len(fingerprints))
+	return fingerprints
+}
+
 // ProfileList lists all available profiles
 func ProfileList() error {
 	// Get custom profiles
@@ -113,7 +154,8 @@ func ProfileList() error {
 	// Get SSH config profiles
 	sshProfiles, err := ParseSSHConfig()
 	if err != nil {
-		// Don't fail if SSH config doesn't exist
+		// Don't fail the whole listing if SSH config is broken
+		fmt.Fprintf(os.Stderr, "⚠️  Could not read ~/.ssh/config: %v\n\n", err)
 		sshProfiles = make(map[string]Profile)
 	}
 
@@ -206,9 +248,112 @@ func ProfileShow(name string) error {
 	if profile.Key != "" {
 		fmt.Printf("  Key:      %s\n", profile.Key)
 	}
+	if profile.Certificate != "" {
+		fmt.Printf("  Cert:     %s\n", profile.Certificate)
+	}
 	if profile.Password != "" {
 		fmt.Printf("  Password: (stored)\n")
 	}
+	if profile.SocksUser != "" {
+		socksPassword := "prompted on connect"
+		if profile.EncryptedSocksPassword != "" || profile.SocksPassword != "" {
+			socksPassword = "stored"
+		}
+		fmt.Printf("  SOCKS:    user %s, password %s\n", profile.SocksUser, socksPassword)
+	}
+	for _, fp := range profile.HostKeyFingerprints {
+		fmt.Printf("  Pinned:   %s\n", fp)
+	}
+	for _, ca := range profile.HostCA {
+		fmt.Printf("  Host CA:  %s\n", ca)
+	}
+	if profile.StrictHostKeyChecking != "" {
+		fmt.Printf("  Strict:   %s\n", profile.StrictHostKeyChecking)
+	}
+	if profile.ForwardAgent {
+		fmt.Printf("  Agent:    forwarded\n")
+	}
+	for _, fwd := range profile.Forwards {
+		fmt.Printf("  Forward:  -L %s\n", fwd)
+	}
+	for _, fwd := range profile.RemoteForwards {
+		fmt.Printf("  Forward:  -R %s\n", fwd)
+	}
+	for _, fwd := range profile.DynamicForwards {
+		fmt.Printf("  Forward:  -D %s\n", fwd)
+	}
+	for _, key := range profile.IdentityFiles {
+		fmt.Printf("  Key:      %s\n", key)
+	}
+	if profile.IdentitiesOnly {
+		fmt.Printf("  Keys:     identities only\n")
+	}
+	if len(profile.Jump) > 0 {
+		fmt.Printf("  Jump:     %s\n", strings.Join(profile.Jump, ","))
+	}
+	if profile.ProxyCommand != "" {
+		fmt.Printf("  Proxy:    %s\n", profile.ProxyCommand)
+	}
+	if len(profile.Jump) > 0 && profile.ProxyCommand != "" {
+		fmt.Printf("  ⚠️  jump and proxy_command cannot be used together, connecting will fail\n")
+	}
+	if profile.KnownHostsFile != "" {
+		fmt.Printf("  Known:    %s\n", profile.KnownHostsFile)
+	}
+	if profile.ConnectTimeout > 0 {
+		fmt.Printf("  Timeout:  %ds\n", profile.ConnectTimeout)
+	}
+	if profile.AddressFamily != "" && profile.AddressFamily != AddressFamilyAny {
+		fmt.Printf("  Family:   %s\n", profile.AddressFamily)
+	}
+	if profile.BindAddress != "" {
+		fmt.Printf("  Bind:     %s\n", profile.BindAddress)
+	}
+	if profile.BindInterface != "" {
+		fmt.Printf("  Bind:     interface %s\n", profile.BindInterface)
+	}
+	if profile.ServerAliveInterval > 0 {
+		fmt.Printf("  Alive:    every %ds, max %d\n", profile.ServerAliveInterval, profile.ServerAliveCountMax)
+	}
+	if profile.Reconnect {
+		fmt.Printf("  Reconnect: yes\n")
+	}
+	if profile.Reattach != "" {
+		fmt.Printf("  Reattach: %s\n", profile.Reattach)
+	}
+	if profile.ControlMaster {
+		fmt.Printf("  Control:  shared connection (persist %s)\n", cmp.Or(profile.ControlPersist, DefaultControlPersist.String()))
+	}
+	if len(profile.SendEnv) > 0 {
+		fmt.Printf("  SendEnv:  %s\n", strings.Join(profile.SendEnv, " "))
+	}
+	for _, pair := range profile.SetEnv {
+		fmt.Printf("  SetEnv:   %s\n", pair)
+	}
+	if profile.RequestTTY != "" {
+		fmt.Printf("  TTY:      %s\n", profile.RequestTTY)
+	}
+	if profile.RemoteCommand != "" {
+		fmt.Printf("  Command:  %s\n", profile.RemoteCommand)
+	}
+	if profile.Ciphers != "" {
+		fmt.Printf("  Ciphers:  %s\n", profile.Ciphers)
+	}
+	if profile.KexAlgorithms != "" {
+		fmt.Printf("  Kex:      %s\n", profile.KexAlgorithms)
+	}
+	if profile.MACs != "" {
+		fmt.Printf("  MACs:     %s\n", profile.MACs)
+	}
+	if profile.HostKeyAlgorithms != "" {
+		fmt.Printf("  HostKeys: %s\n", profile.HostKeyAlgorithms)
+	}
+	if profile.Compression {
+		fmt.Printf("  Compression: requested (not supported)\n")
+	}
+	if len(profile.IgnoredDirectives) > 0 {
+		fmt.Printf("\n⚠️  Ignored ~/.ssh/config directives: %s\n", strings.Join(profile.IgnoredDirectives, ", "))
+	}
 
 	return nil
 }
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// ExpandProxyCommand expands the OpenSSH tokens supported in ProxyCommand:
+// %h (host), %p (port), %r (remote user), %n (host as given by the user),
+// %u (local user), %d (home directory) and %%
+func ExpandProxyCommand(command, host, port, user, alias string) string {
+	home, _ := os.UserHomeDir()
+	replacer := strings.NewReplacer(
+		"%%", "%",
+		"%h", host,
+		"%p", port,
+		"%r", user,
+		"%n", alias,
+		"%u", localUserName(),
+		"%d", home,
+	)
+	return replacer.Replace(command)
+}
+
+// shellCommand returns a command that runs through the system shell
+func shellCommand(command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.Command("cmd", "/C", command)
+	}
+	return exec.Command("/bin/sh", "-c", command)
+}
+
+// proxyCommandConn is a net.Conn backed by a child process's stdin and stdout
+type proxyCommandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+}
+
+// dialProxyCommand starts the command through the system shell and returns
+// a connection that talks to the process
+func dialProxyCommand(command string) (net.Conn, error) {
+	cmd := shellCommand(command)
+	cmd.Stderr = os.Stderr
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdin pipe: %w", err)
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create stdout pipe: %w", err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start proxy command: %w", err)
+	}
+
+	return &proxyCommandConn{cmd: cmd, stdin: stdin, stdout: stdout}, nil
+}
+
+func (p *proxyCommandConn) Read(b []byte) (int, error) {
+	return p.stdout.Read(b)
+}
+
+func (p *proxyCommandConn) Write(b []byte) (int, error) {
+	return p.stdin.Write(b)
+}
+
+// Close closes the pipes and stops the proxy process
+func (p *proxyCommandConn) Close() error {
+	p.stdin.Close()
+	if p.cmd.Process != nil {
+		p.cmd.Process.Kill()
+	}
+	p.cmd.Wait()
+	return nil
+}
+
+// The process has no real network addresses; report an unspecified TCP
+// address so host key verification can still parse it
+func (p *proxyCommandConn) LocalAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4zero}
+}
+
+func (p *proxyCommandConn) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4zero}
+}
+
+// Deadlines are not supported on process pipes
+func (p *proxyCommandConn) SetDeadline(t time.Time) error      { return nil }
+func (p *proxyCommandConn) SetReadDeadline(t time.Time) error  { return nil }
+func (p *proxyCommandConn) SetWriteDeadline(t time.Time) error { return nil }
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ReconnectOptions limits how long a dropped connection is retried
+// The limits apply to each outage separately.
+type ReconnectOptions struct {
+	MaxAttempts int           // Attempts before giving up, 0 for no limit
+	MaxDuration time.Duration // Time before giving up, 0 for no limit
+}
+
+// Delay between reconnect attempts: it doubles from reconnectMinDelay up
+// to reconnectMaxDelay and is randomized so clients do not retry in step
+const (
+	reconnectMinDelay = time.Second
+	reconnectMaxDelay = 30 * time.Second
+)
+
+// DefaultReconnectKeepAlive is the keepalive interval used with reconnect
+// when none is configured, so dead connections are noticed
+const DefaultReconnectKeepAlive = 15 * time.Second
+
+// aliveTimeout is how long alive waits for the server to answer
+const aliveTimeout = 5 * time.Second
+
+// Supervise runs session and reconnects when it ends because the connection
+// dropped; with restart set the session is then run again, otherwise only
+// the connection (and the forwards using it) is kept up
+// It returns when session ends normally or reconnecting fails.
+func (c *SSHClient) Supervise(opts ReconnectOptions, session func() error, restart bool) error {
+	for {
+		err := session()
+		if err == nil || c.alive() {
+			// Finished, or failed for a reason other than the connection
+			return err
+		}
+
+		if err := c.Reconnect(opts); err != nil {
+			return err
+		}
+		if !restart {
+			session = c.Wait
+		}
+	}
+}
+
+// Reconnect closes what is left of the connection and connects again,
+// retrying with exponential backoff until a limit of opts is reached
+// Local and dynamic forwards keep listening and use the new connection,
+// remote forwards are requested again.
+func (c *SSHClient) Reconnect(opts ReconnectOptions) error {
+	c.disconnect()
+
+	addr := net.JoinHostPort(c.host, c.port)
+	start := time.Now()
+	delay := reconnectMinDelay
+	for attempt := 1; ; attempt++ {
+		wait := delay/2 + rand.N(delay/2)
+		limit := ""
+		if opts.MaxAttempts > 0 {
+			limit = fmt.Sprintf("/%d", opts.MaxAttempts)
+		}
+		fmt.Fprintf(os.Stderr, "🔄 Connection to %s lost, reconnecting in %.1fs (attempt %d%s)\n",
+			addr, wait.Seconds(), attempt, limit)
+		time.Sleep(wait)
+
+		err := c.Connect()
+		if err == nil {
+			fmt.Fprintf(os.Stderr, "✅ Reconnected to %s\n", addr)
+			c.restoreRemoteForwards()
+			return nil
+		}
+		fmt.Fprintf(os.Stderr, "   Attempt %d failed: %v\n", attempt, err)
+		c.disconnect()
+
+		if opts.MaxAttempts > 0 && attempt >= opts.MaxAttempts {
+			return fmt.Errorf("gave up reconnecting to %s after %d attempts: %w", addr, attempt, err)
+		}
+		if opts.MaxDuration > 0 && time.Since(start) >= opts.MaxDuration {
+			return fmt.Errorf("gave up reconnecting to %s after %s: %w", addr, opts.MaxDuration, err)
+		}
+		delay = min(delay*2, reconnectMaxDelay)
+	}
+}
+
+// restoreRemoteForwards requests the remote forwards again on a new connection
+// The server may still hold the port for the dropped connection, so
+// failures are reported without giving up on the connection.
+func (c *SSHClient) restoreRemoteForwards() {
+	for _, fwd := range c.remoteForwards {
+		addr, err := c.listenRemoteForward(fwd)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "⚠️  Remote forward %s not restored: %v\n", fwd.BindAddress, err)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Forwarding remote %s -> %s\n", addr, fwd.Target)
+	}
+}
+
+// disconnect closes the connection and those of the jump hosts, keeping
+// the local forward listeners open
+func (c *SSHClient) disconnect() {
+	if client := c.currentClient(); client != nil {
+		client.Close()
+		c.setClient(nil)
+	}
+	if c.jump != nil {
+		c.jump.disconnect()
+	}
+}
+
+// alive reports whether the connection still answers requests
+func (c *SSHClient) alive() bool {
+	client := c.currentClient()
+	if client == nil {
+		return false
+	}
+
+	reply := make(chan error, 1)
+	go func() {
+		_, _, err := client.SendRequest(keepAliveRequest, true, nil)
+		reply <- err
+	}()
+
+	select {
+	case err := <-reply:
+		return err == nil
+	case <-time.After(aliveTimeout):
+		return false
+	}
+}
+
+// sessionNamePattern restricts tmux and screen session names to characters
+// that are safe to pass through the remote shell
+var sessionNamePattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Reattach modes for the interactive session after reconnecting
+const (
+	ReattachShell  = "shell"  // Start a new login shell
+	ReattachTmux   = "tmux"   // Attach to (or create) a tmux session
+	ReattachScreen = "screen" // Attach to (or create) a screen session
+)
+
+// defaultSessionName is the tmux or screen session name used when none is given
+const defaultSessionName = "sshclient"
+
+// ParseReattach parses a reattach setting: shell, tmux[:name] or
+// screen[:name]
+// It returns the remote command for the interactive session (empty for the
+// login shell) and whether the session is restarted after reconnecting.
+func ParseReattach(value string) (command string, restart bool, err error) {
+	mode, name, _ := strings.Cut(strings.TrimSpace(value), ":")
+	if name == "" {
+		name = defaultSessionName
+	}
+
+	switch strings.ToLower(mode) {
+	case "":
+		return "", false, nil
+	case ReattachShell:
+		return "", true, nil
+	case ReattachTmux, ReattachScreen:
+	default:
+		return "", false, fmt.Errorf("invalid reattach value %q (use shell, tmux[:name] or screen[:name])", value)
+	}
+
+	if !sessionNamePattern.MatchString(name) {
+		return "", false, fmt.Errorf("invalid session name %q (use letters, digits, '.', '_' and '-')", name)
+	}
+	if strings.ToLower(mode) == ReattachTmux {
+		return "tmux new-session -A -s " + name, true, nil
+	}
+	return "screen -D -R -S " + name, true, nil
+}
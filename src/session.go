@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+)
+
+// RequestTTY values, as in OpenSSH
+const (
+	RequestTTYAuto  = "auto"  // Pseudo terminal for interactive shells only (default)
+	RequestTTYYes   = "yes"   // Also for commands, when stdin is a terminal
+	RequestTTYForce = "force" // Also for commands, always
+	RequestTTYNo    = "no"    // Never for commands
+)
+
+// ParseRequestTTY validates a RequestTTY value
+func ParseRequestTTY(value string) (string, error) {
+	switch mode := strings.ToLower(strings.TrimSpace(value)); mode {
+	case "":
+		return RequestTTYAuto, nil
+	case RequestTTYAuto, RequestTTYYes, RequestTTYForce, RequestTTYNo:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid RequestTTY value %q (use auto, yes, force or no)", value)
+	}
+}
+
+// SessionEnv builds the environment sent to the server from SendEnv
+// patterns, matched against the local environment, and SetEnv NAME=value
+// pairs, which take precedence
+func SessionEnv(sendEnv, setEnv []string) map[string]string {
+	env := make(map[string]string)
+	for _, entry := range os.Environ() {
+		name, value, _ := strings.Cut(entry, "=")
+		for _, pattern := range sendEnv {
+			// Unlike host names, variable names are case-sensitive
+			if wildcardMatchCase(pattern, name) {
+				env[name] = value
+				break
+			}
+		}
+	}
+	for _, pair := range setEnv {
+		if name, value, ok := strings.Cut(pair, "="); ok && name != "" {
+			env[name] = value
+		}
+	}
+	return env
+}
+
+// SetEnv sets environment variables sent on shell and command sessions
+func (c *SSHClient) SetEnv(env map[string]string) {
+	c.env = env
+}
+
+// setSessionEnv sends the client's environment variables for the session
+// Servers only accept variables allowed by AcceptEnv, others are silently
+// dropped like OpenSSH does.
+func (c *SSHClient) setSessionEnv(session *ssh.Session) {
+	for name, value := range c.env {
+		session.Setenv(name, value)
+	}
+}
+
+// commandWantsTTY reports whether a command should run on a pseudo terminal
+// for a validated RequestTTY mode
+func commandWantsTTY(mode string) bool {
+	switch mode {
+	case RequestTTYForce:
+		return true
+	case RequestTTYYes:
+		return term.IsTerminal(int(os.Stdin.Fd()))
+	}
+	return false
+}
+
+// stdinPollInterval is how often a session waiting for input checks
+// whether it has ended
+const stdinPollInterval = 50 * time.Millisecond
+
+// stdinPump hands standard input to one terminal session at a time
+// It only reads once input is waiting. Giving each session os.Stdin
+// directly leaves the copy goroutine of a dropped session blocked in Read,
+// where it takes what is typed at the prompts before the next session,
+// such as a password, and sends it to that next session.
+type stdinPump struct {
+	in  *os.File
+	err error // Error that ended the input, such as io.EOF
+}
+
+// terminalStdin feeds standard input to interactive sessions
+var terminalStdin = &stdinPump{in: os.Stdin}
+
+// feed copies input to w until done is closed, or until the input ends,
+// which closes w
+// Sessions must not feed at the same time.
+func (p *stdinPump) feed(w io.WriteCloser, done <-chan struct{}) {
+	buf := make([]byte, 32*1024)
+	for p.err == nil {
+		select {
+		case <-done:
+			return
+		default:
+		}
+
+		ready, err := waitReadable(p.in, stdinPollInterval)
+		if err != nil {
+			p.err = err
+			break
+		}
+		if !ready {
+			continue
+		}
+
+		n, err := p.in.Read(buf)
+		p.err = err
+		if n > 0 {
+			if _, err := w.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+	}
+	w.Close()
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestSessionEnv(t *testing.T) {
+	t.Setenv("LANG", "en_US.UTF-8")
+	t.Setenv("lang", "lower")
+	t.Setenv("LC_ALL", "C")
+	t.Setenv("LC_TIME", "de_DE")
+	t.Setenv("LCX", "no")
+
+	env := SessionEnv([]string{"LANG", "LC_*"}, []string{"LC_TIME=fr_FR", "EDITOR=vi", "=ignored", "BROKEN"})
+
+	want := map[string]string{
+		"LANG":    "en_US.UTF-8",
+		"LC_ALL":  "C",
+		"LC_TIME": "fr_FR", // SetEnv wins over SendEnv
+		"EDITOR":  "vi",
+	}
+	for name, value := range want {
+		if env[name] != value {
+			t.Errorf("env[%s] = %q, want %q", name, env[name], value)
+		}
+	}
+	for _, name := range []string{"lang", "LCX", "BROKEN", ""} {
+		if _, ok := env[name]; ok {
+			t.Errorf("env contains %q", name)
+		}
+	}
+}
+
+func TestWildcardMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		s       string
+		match   bool
+		exact   bool // Result of the case-sensitive match
+	}{
+		{pattern: "*.example.com", s: "Web.Example.COM", match: true, exact: false},
+		{pattern: "web-?", s: "web-1", match: true, exact: true},
+		{pattern: "web-?", s: "web-10", match: false, exact: false},
+		{pattern: "LC_*", s: "lc_all", match: true, exact: false},
+		{pattern: "LC_*", s: "LC_ALL", match: true, exact: true},
+		{pattern: "*", s: "", match: true, exact: true},
+	}
+	for _, tt := range tests {
+		if got := wildcardMatch(tt.pattern, tt.s); got != tt.match {
+			t.Errorf("wildcardMatch(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.match)
+		}
+		if got := wildcardMatchCase(tt.pattern, tt.s); got != tt.exact {
+			t.Errorf("wildcardMatchCase(%q, %q) = %v, want %v", tt.pattern, tt.s, got, tt.exact)
+		}
+	}
+}
+
+// pipeWriter collects what a session receives on its stdin
+type pipeWriter struct {
+	data   chan []byte
+	closed chan struct{}
+}
+
+func newPipeWriter() *pipeWriter {
+	return &pipeWriter{data: make(chan []byte, 8), closed: make(chan struct{})}
+}
+
+func (w *pipeWriter) Write(p []byte) (int, error) {
+	w.data <- append([]byte(nil), p...)
+	return len(p), nil
+}
+
+func (w *pipeWriter) Close() error {
+	close(w.closed)
+	return nil
+}
+
+// startFeed feeds a session from the pump until the returned function is
+// called, which waits for feed to return like runTerminalSession does
+func startFeed(pump *stdinPump, w *pipeWriter) func() {
+	done := make(chan struct{})
+	fed := make(chan struct{})
+	go func() {
+		pump.feed(w, done)
+		close(fed)
+	}()
+	return func() {
+		close(done)
+		<-fed
+	}
+}
+
+func TestStdinPumpLeavesInputToPrompts(t *testing.T) {
+	input, typed, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer input.Close()
+	pump := &stdinPump{in: input}
+
+	// The first session is waiting for input when its connection drops
+	first := newPipeWriter()
+	stop := startFeed(pump, first)
+	typed.Write([]byte("ls\n"))
+	if got := <-first.data; string(got) != "ls\n" {
+		t.Fatalf("first session got %q, want %q", got, "ls\n")
+	}
+	stop()
+
+	// A password prompt before the next session reads standard input
+	typed.Write([]byte("hunter2\n"))
+	answer := make([]byte, 64)
+	n, err := input.Read(answer)
+	if err != nil || string(answer[:n]) != "hunter2\n" {
+		t.Fatalf("prompt read %q (%v), want %q", answer[:n], err, "hunter2\n")
+	}
+
+	// Later input goes to the next session, and none to the finished one
+	second := newPipeWriter()
+	defer startFeed(pump, second)()
+	typed.Write([]byte("tmux attach\n"))
+	select {
+	case got := <-second.data:
+		if string(got) != "tmux attach\n" {
+			t.Fatalf("second session got %q, want %q", got, "tmux attach\n")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second session did not get the input")
+	}
+	select {
+	case got := <-first.data:
+		t.Fatalf("finished session got %q", got)
+	default:
+	}
+
+	// The end of the input closes the session's stdin
+	typed.Close()
+	select {
+	case <-second.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("end of input did not close the session's stdin")
+	}
+}
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+
+	"golang.org/x/term"
+)
+
+// SOCKS5 protocol constants (RFC 1928, RFC 1929)
+const (
+	socksVersion         = 0x05
+	socksAuthVersion     = 0x01
+	socksMethodNoAuth    = 0x00
+	socksMethodPassword  = 0x02
+	socksMethodNoAccept  = 0xFF
+	socksCmdConnect      = 0x01
+	socksAtypIPv4        = 0x01
+	socksAtypDomain      = 0x03
+	socksAtypIPv6        = 0x04
+	socksReplySucceeded  = 0x00
+	socksReplyFailure    = 0x01
+	socksReplyCmdUnsupp  = 0x07
+	socksReplyAtypUnsupp = 0x08
+)
+
+// socksPasswordEnv names the environment variable holding the SOCKS5 proxy
+// password, so it does not have to be typed or given on the command line
+const socksPasswordEnv = "SSHCLIENT_SOCKS_PASSWORD"
+
+// DynamicForward describes a dynamic SOCKS5 forward (-D)
+// Every CONNECT request accepted on BindAddress is dialed through the SSH server
+type DynamicForward struct {
+	BindAddress string // Local listen address (host:port)
+	Username    string // Optional username required from SOCKS clients
+	Password    string // Optional password required from SOCKS clients
+}
+
+// ParseDynamicForward parses a -D specification in the form [bind_address:]port
+func ParseDynamicForward(spec string) (DynamicForward, error) {
+	parts, err := splitForwardSpec(spec)
+	if err != nil {
+		return DynamicForward{}, fmt.Errorf("invalid dynamic forward: %w", err)
+	}
+
+	var bind, port string
+	switch len(parts) {
+	case 1:
+		bind, port = "localhost", parts[0]
+	case 2:
+		bind, port = parts[0], parts[1]
+	default:
+		return DynamicForward{}, fmt.Errorf("invalid dynamic forward %q: expected [bind_address:]port", spec)
+	}
+
+	if port == "" {
+		return DynamicForward{}, fmt.Errorf("invalid dynamic forward %q: empty port", spec)
+	}
+	if bind == "*" {
+		bind = ""
+	}
+
+	return DynamicForward{BindAddress: net.JoinHostPort(bind, port)}, nil
+}
+
+// StartDynamicForward starts a local SOCKS5 proxy that routes every
+// connection through the SSH connection
+func (c *SSHClient) StartDynamicForward(fwd DynamicForward) (net.Addr, error) {
+	if c.client == nil {
+		return nil, fmt.Errorf("not connected")
+	}
+
+	listener, err := net.Listen("tcp", fwd.BindAddress)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", fwd.BindAddress, err)
+	}
+	c.listeners = append(c.listeners, listener)
+
+	go c.serveDynamicForward(listener, fwd)
+	return listener.Addr(), nil
+}
+
+// serveDynamicForward accepts SOCKS clients until the listener is closed
+func (c *SSHClient) serveDynamicForward(listener net.Listener, fwd DynamicForward) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go func() {
+			if err := c.handleSocksConn(conn, fwd); err != nil {
+				fmt.Fprintf(os.Stderr, "SOCKS %s: %v\n", fwd.BindAddress, err)
+			}
+		}()
+	}
+}
+
+// handleSocksConn performs the SOCKS5 handshake and tunnels the connection
+func (c *SSHClient) handleSocksConn(conn net.Conn, fwd DynamicForward) error {
+	if err := socksNegotiateAuth(conn, fwd); err != nil {
+		conn.Close()
+		return err
+	}
+
+	target, err := socksReadRequest(conn)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	client := c.currentClient()
+	if client == nil {
+		socksReply(conn, socksReplyFailure)
+		conn.Close()
+		return fmt.Errorf("not connected")
+	}
+	remote, err := client.Dial("tcp", target)
+	if err != nil {
+		socksReply(conn, socksReplyFailure)
+		conn.Close()
+		return fmt.Errorf("failed to connect to %s: %w", target, err)
+	}
+
+	if err := socksReply(conn, socksReplySucceeded); err != nil {
+		remote.Close()
+		conn.Close()
+		return err
+	}
+
+	pipe(conn, remote)
+	return nil
+}
+
+// socksNegotiateAuth selects an authentication method and, when
+// credentials are configured, verifies the client's username and password
+func socksNegotiateAuth(conn net.Conn, fwd DynamicForward) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("failed to read greeting: %w", err)
+	}
+	if header[0] != socksVersion {
+		return fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return fmt.Errorf("failed to read auth methods: %w", err)
+	}
+
+	want := byte(socksMethodNoAuth)
+	if fwd.Username != "" || fwd.Password != "" {
+		want = socksMethodPassword
+	}
+
+	offered := false
+	for _, m := range methods {
+		if m == want {
+			offered = true
+			break
+		}
+	}
+	if !offered {
+		conn.Write([]byte{socksVersion, socksMethodNoAccept})
+		return errors.New("client did not offer an acceptable auth method")
+	}
+
+	if _, err := conn.Write([]byte{socksVersion, want}); err != nil {
+		return err
+	}
+
+	if want == socksMethodPassword {
+		return socksCheckPassword(conn, fwd)
+	}
+	return nil
+}
+
+// socksCheckPassword runs the username/password subnegotiation (RFC 1929)
+func socksCheckPassword(conn net.Conn, fwd DynamicForward) error {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("failed to read credentials: %w", err)
+	}
+	if header[0] != socksAuthVersion {
+		return fmt.Errorf("unsupported auth version %d", header[0])
+	}
+
+	username := make([]byte, header[1])
+	if _, err := io.ReadFull(conn, username); err != nil {
+		return fmt.Errorf("failed to read username: %w", err)
+	}
+
+	plen := make([]byte, 1)
+	if _, err := io.ReadFull(conn, plen); err != nil {
+		return fmt.Errorf("failed to read password: %w", err)
+	}
+	password := make([]byte, plen[0])
+	if _, err := io.ReadFull(conn, password); err != nil {
+		return fmt.Errorf("failed to read password: %w", err)
+	}
+
+	userOK := subtle.ConstantTimeCompare(username, []byte(fwd.Username)) == 1
+	passOK := subtle.ConstantTimeCompare(password, []byte(fwd.Password)) == 1
+	if !userOK || !passOK {
+		conn.Write([]byte{socksAuthVersion, 0x01})
+		return errors.New("authentication failed")
+	}
+
+	_, err := conn.Write([]byte{socksAuthVersion, 0x00})
+	return err
+}
+
+// socksReadRequest reads a CONNECT request and returns the target address
+func socksReadRequest(conn net.Conn) (string, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return "", fmt.Errorf("failed to read request: %w", err)
+	}
+	if header[0] != socksVersion {
+		return "", fmt.Errorf("unsupported SOCKS version %d", header[0])
+	}
+	if header[1] != socksCmdConnect {
+		socksReply(conn, socksReplyCmdUnsupp)
+		return "", fmt.Errorf("unsupported command %d", header[1])
+	}
+
+	var host string
+	switch header[3] {
+	case socksAtypIPv4:
+		ip := make([]byte, net.IPv4len)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", fmt.Errorf("failed to read address: %w", err)
+		}
+		host = net.IP(ip).String()
+	case socksAtypIPv6:
+		ip := make([]byte, net.IPv6len)
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", fmt.Errorf("failed to read address: %w", err)
+		}
+		host = net.IP(ip).String()
+	case socksAtypDomain:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return "", fmt.Errorf("failed to read address: %w", err)
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", fmt.Errorf("failed to read address: %w", err)
+		}
+		host = string(domain)
+	default:
+		socksReply(conn, socksReplyAtypUnsupp)
+		return "", fmt.Errorf("unsupported address type %d", header[3])
+	}
+
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return "", fmt.Errorf("failed to read port: %w", err)
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// socksReply sends a reply with an unspecified IPv4 bind address
+func socksReply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{socksVersion, code, 0x00, socksAtypIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// promptSocksPassword asks for the password SOCKS5 clients must give
+func promptSocksPassword(user string) (string, error) {
+	fmt.Printf("SOCKS5 proxy password for %s: ", user)
+	passwordBytes, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Println()
+	if err != nil {
+		return "", fmt.Errorf("failed to read SOCKS password (set $%s instead): %w", socksPasswordEnv, err)
+	}
+	if len(passwordBytes) == 0 {
+		return "", fmt.Errorf("SOCKS password is required when a SOCKS user is set")
+	}
+	return string(passwordBytes), nil
+}
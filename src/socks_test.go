@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+// socksHandshake runs the server side of the handshake on one end of a pipe
+// while client writes its messages to the other end, and returns the target,
+// everything the server replied and the server error
+func socksHandshake(t *testing.T, fwd DynamicForward, client []byte) (string, []byte, error) {
+	t.Helper()
+
+	server, conn := net.Pipe()
+	type result struct {
+		target string
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		defer server.Close()
+		if err := socksNegotiateAuth(server, fwd); err != nil {
+			done <- result{err: err}
+			return
+		}
+		target, err := socksReadRequest(server)
+		done <- result{target: target, err: err}
+	}()
+
+	// The pipe is synchronous, so write while collecting the replies
+	go func() {
+		conn.Write(client)
+	}()
+	replies, _ := io.ReadAll(conn)
+	conn.Close()
+
+	res := <-done
+	return res.target, replies, res.err
+}
+
+func TestSocksHandshake(t *testing.T) {
+	connectDomain := []byte{socksVersion, socksCmdConnect, 0x00, socksAtypDomain, 11}
+	connectDomain = append(connectDomain, "example.com"...)
+	connectDomain = append(connectDomain, 0x00, 0x50)
+
+	tests := []struct {
+		name    string
+		fwd     DynamicForward
+		client  [][]byte
+		target  string
+		wantErr bool
+		replies []byte
+	}{
+		{
+			name: "no auth, IPv4",
+			client: [][]byte{
+				{socksVersion, 1, socksMethodNoAuth},
+				{socksVersion, socksCmdConnect, 0x00, socksAtypIPv4, 10, 0, 0, 1, 0x1F, 0x90},
+			},
+			target:  "10.0.0.1:8080",
+			replies: []byte{socksVersion, socksMethodNoAuth},
+		},
+		{
+			name: "no auth, domain",
+			client: [][]byte{
+				{socksVersion, 2, socksMethodPassword, socksMethodNoAuth},
+				connectDomain,
+			},
+			target:  "example.com:80",
+			replies: []byte{socksVersion, socksMethodNoAuth},
+		},
+		{
+			name: "no auth, IPv6",
+			client: [][]byte{
+				{socksVersion, 1, socksMethodNoAuth},
+				append([]byte{socksVersion, socksCmdConnect, 0x00, socksAtypIPv6},
+					append(net.ParseIP("2001:db8::1"), 0x00, 0x16)...),
+			},
+			target:  "[2001:db8::1]:22",
+			replies: []byte{socksVersion, socksMethodNoAuth},
+		},
+		{
+			name: "password accepted",
+			fwd:  DynamicForward{Username: "user", Password: "secret"},
+			client: [][]byte{
+				{socksVersion, 1, socksMethodPassword},
+				append(append([]byte{socksAuthVersion, 4}, "user"...), append([]byte{6}, "secret"...)...),
+				{socksVersion, socksCmdConnect, 0x00, socksAtypIPv4, 127, 0, 0, 1, 0x00, 0x50},
+			},
+			target:  "127.0.0.1:80",
+			replies: []byte{socksVersion, socksMethodPassword, socksAuthVersion, 0x00},
+		},
+		{
+			name: "password rejected",
+			fwd:  DynamicForward{Username: "user", Password: "secret"},
+			client: [][]byte{
+				{socksVersion, 1, socksMethodPassword},
+				append(append([]byte{socksAuthVersion, 4}, "user"...), append([]byte{5}, "wrong"...)...),
+			},
+			wantErr: true,
+			replies: []byte{socksVersion, socksMethodPassword, socksAuthVersion, 0x01},
+		},
+		{
+			name: "password required but not offered",
+			fwd:  DynamicForward{Username: "user", Password: "secret"},
+			client: [][]byte{
+				{socksVersion, 1, socksMethodNoAuth},
+			},
+			wantErr: true,
+			replies: []byte{socksVersion, socksMethodNoAccept},
+		},
+		{
+			name: "SOCKS4 rejected",
+			client: [][]byte{
+				{0x04, 1, socksMethodNoAuth},
+			},
+			wantErr: true,
+		},
+		{
+			name: "BIND rejected",
+			client: [][]byte{
+				{socksVersion, 1, socksMethodNoAuth},
+				{socksVersion, 0x02, 0x00, socksAtypIPv4, 10, 0, 0, 1, 0x1F, 0x90},
+			},
+			wantErr: true,
+			replies: []byte{socksVersion, socksMethodNoAuth,
+				socksVersion, socksReplyCmdUnsupp, 0x00, socksAtypIPv4, 0, 0, 0, 0, 0, 0},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			target, replies, err := socksHandshake(t, tt.fwd, bytes.Join(tt.client, nil))
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("handshake succeeded with target %q, want error", target)
+				}
+			} else if err != nil {
+				t.Fatalf("handshake error: %v", err)
+			}
+			if target != tt.target {
+				t.Errorf("target = %q, want %q", target, tt.target)
+			}
+			if !bytes.Equal(replies, tt.replies) {
+				t.Errorf("replies = %v, want %v", replies, tt.replies)
+			}
+		})
+	}
+}
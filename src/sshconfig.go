@@ -0,0 +1,705 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// errNotInSSHConfig is returned for hosts that no Host or Match block names
+var errNotInSSHConfig = errors.New("not found in SSH config")
+
+// maxIncludeDepth limits nested Include directives, like OpenSSH
+const maxIncludeDepth = 16
+
+// sshConfigOption is a single directive from ~/.ssh/config
+type sshConfigOption struct {
+	Key   string // Directive name in lower case
+	Value string
+}
+
+// sshMatchCriterion is one criterion of a Match line, e.g. "host web-*"
+type sshMatchCriterion struct {
+	Name    string // all, host, originalhost, user, localuser, exec, canonical or final
+	Negated bool
+	Arg     string
+}
+
+// sshConfigBlock is a Host or Match block of ~/.ssh/config
+// Directives before the first Host or Match line form a block without
+// patterns or criteria, which applies to every host.
+type sshConfigBlock struct {
+	Patterns []string            // Host patterns
+	Match    []sshMatchCriterion // Match criteria
+	Within   []sshConfigBlock    // Conditions of the blocks whose Include brought this block in
+	Options  []sshConfigOption
+}
+
+// unconditional reports whether the block applies to every host
+func (b sshConfigBlock) unconditional() bool {
+	return b.Patterns == nil && b.Match == nil && len(b.Within) == 0
+}
+
+// condition returns the block without its options, for use in Within
+func (b sshConfigBlock) condition() sshConfigBlock {
+	return sshConfigBlock{Patterns: b.Patterns, Match: b.Match, Within: b.Within}
+}
+
+// SSHConfig is a parsed ~/.ssh/config, including the files it includes
+type SSHConfig struct {
+	blocks []sshConfigBlock
+	home   string
+
+	within []sshConfigBlock // Conditions of the Includes being read
+	execs  map[string]bool  // Results of Match exec commands while resolving
+}
+
+// GetSSHConfigPath returns the path to the user's OpenSSH config file
+func GetSSHConfigPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".ssh", "config"), nil
+}
+
+// LoadSSHConfig reads ~/.ssh/config (an empty config if it does not exist)
+func LoadSSHConfig() (*SSHConfig, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	configPath, err := GetSSHConfigPath()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &SSHConfig{home: home}
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		return config, nil
+	}
+
+	if err := config.readFile(configPath, 0); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// readFile parses an OpenSSH config file into blocks
+// Included files are parsed in place. An Include inside a Host or Match
+// block only applies while that block's condition holds: every block of the
+// included file also requires it, so the whole file is inactive when the
+// including block is. The including block continues after the included
+// file, whatever Host or Match lines it had.
+func (c *SSHConfig) readFile(configPath string, depth int) error {
+	if depth > maxIncludeDepth {
+		return fmt.Errorf("%s: Include nested too deeply (limit %d)", configPath, maxIncludeDepth)
+	}
+
+	file, err := os.Open(configPath)
+	if err != nil {
+		return fmt.Errorf("failed to open SSH config: %w", err)
+	}
+	defer file.Close()
+
+	// Lines before the first Host or Match only depend on the including block
+	c.blocks = append(c.blocks, sshConfigBlock{Within: slices.Clone(c.within)})
+
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+
+		// Skip empty lines and comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, rest, args, err := splitSSHConfigLine(line)
+		if err != nil {
+			return fmt.Errorf("%s line %d: %w", configPath, lineNum, err)
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		switch key {
+		case "host":
+			c.blocks = append(c.blocks, sshConfigBlock{Patterns: args, Within: slices.Clone(c.within)})
+
+		case "match":
+			criteria, err := parseMatchCriteria(args)
+			if err != nil {
+				return fmt.Errorf("%s line %d: %w", configPath, lineNum, err)
+			}
+			c.blocks = append(c.blocks, sshConfigBlock{Match: criteria, Within: slices.Clone(c.within)})
+
+		case "include":
+			for _, pattern := range args {
+				// Errors from included files already name the file
+				if err := c.include(pattern, depth); err != nil {
+					return err
+				}
+			}
+
+		default:
+			// A single (possibly quoted) argument is unquoted, longer values
+			// such as ProxyCommand are kept as written
+			value := rest
+			if len(args) == 1 {
+				value = args[0]
+			}
+			current := &c.blocks[len(c.blocks)-1]
+			current.Options = append(current.Options, sshConfigOption{Key: key, Value: value})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading SSH config: %w", err)
+	}
+
+	return nil
+}
+
+// include parses the files matching an Include pattern
+// Relative paths are relative to ~/.ssh; patterns matching nothing are ignored.
+func (c *SSHConfig) include(pattern string, depth int) error {
+	pattern = expandHome(pattern)
+	if !filepath.IsAbs(pattern) {
+		pattern = filepath.Join(c.home, ".ssh", pattern)
+	}
+
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return fmt.Errorf("invalid Include pattern %q: %w", pattern, err)
+	}
+
+	// Blocks of the included files require the including block's condition,
+	// and the including block continues after them
+	enclosing := c.blocks[len(c.blocks)-1].condition()
+	outer := c.within
+	if enclosing.Patterns != nil || enclosing.Match != nil {
+		c.within = append(slices.Clone(enclosing.Within), sshConfigBlock{Patterns: enclosing.Patterns, Match: enclosing.Match})
+	}
+	defer func() { c.within = outer }()
+
+	for _, file := range files {
+		if info, err := os.Stat(file); err != nil || info.IsDir() {
+			continue
+		}
+		if err := c.readFile(file, depth+1); err != nil {
+			return err
+		}
+	}
+	c.blocks = append(c.blocks, enclosing)
+	return nil
+}
+
+// splitSSHConfigLine splits a config line into its lower-case keyword, the
+// raw argument text and the arguments
+// Keyword and arguments may be separated by whitespace or "=", arguments
+// may be double-quoted and an unquoted "#" starts a comment.
+func splitSSHConfigLine(line string) (key, rest string, args []string, err error) {
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), "", nil, nil
+	}
+	key = strings.ToLower(line[:end])
+
+	rest = strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimPrefix(rest, "=")
+	rest = strings.TrimSpace(rest)
+
+	var current strings.Builder
+	inQuotes, inToken := false, false
+	for i := 0; i < len(rest); i++ {
+		ch := rest[i]
+		switch {
+		case ch == '"':
+			inQuotes = !inQuotes
+			inToken = true
+		case !inQuotes && (ch == ' ' || ch == '\t'):
+			if inToken {
+				args = append(args, current.String())
+				current.Reset()
+				inToken = false
+			}
+		case !inQuotes && !inToken && ch == '#':
+			rest = strings.TrimSpace(rest[:i])
+			i = len(rest)
+		default:
+			current.WriteByte(ch)
+			inToken = true
+		}
+	}
+	if inQuotes {
+		return "", "", nil, fmt.Errorf("unterminated quote")
+	}
+	if inToken {
+		args = append(args, current.String())
+	}
+
+	return key, rest, args, nil
+}
+
+// parseMatchCriteria parses the arguments of a Match line
+func parseMatchCriteria(args []string) ([]sshMatchCriterion, error) {
+	var criteria []sshMatchCriterion
+	for i := 0; i < len(args); i++ {
+		name := strings.ToLower(args[i])
+		criterion := sshMatchCriterion{Name: strings.TrimPrefix(name, "!")}
+		criterion.Negated = strings.HasPrefix(name, "!")
+
+		switch criterion.Name {
+		case "all":
+			if len(args) != 1 {
+				return nil, fmt.Errorf("Match all cannot be combined with other criteria")
+			}
+		case "canonical", "final":
+		case "host", "originalhost", "user", "localuser", "exec":
+			if i+1 >= len(args) {
+				return nil, fmt.Errorf("Match %s requires an argument", criterion.Name)
+			}
+			i++
+			criterion.Arg = args[i]
+		default:
+			return nil, fmt.Errorf("unsupported Match criterion %q", args[i])
+		}
+
+		criteria = append(criteria, criterion)
+	}
+
+	if len(criteria) == 0 {
+		return nil, fmt.Errorf("Match requires criteria")
+	}
+	return criteria, nil
+}
+
+// matches reports whether a Host block applies to a host name
+// A matching !pattern excludes the host even if another pattern matches.
+func (b sshConfigBlock) matches(name string) bool {
+	return matchPatternList(b.Patterns, name)
+}
+
+// matchPatternList matches s against patterns that may contain * and ?
+// wildcards and !negations; a matching negation always wins
+func matchPatternList(patterns []string, s string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negated := strings.HasPrefix(pattern, "!")
+		if !wildcardMatch(strings.TrimPrefix(pattern, "!"), s) {
+			continue
+		}
+		if negated {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// applies reports whether a block, and every block whose Include brought
+// it in, applies to the target name with the settings resolved so far
+func (c *SSHConfig) applies(block sshConfigBlock, name string, profile *Profile, runExec bool) bool {
+	for _, outer := range block.Within {
+		if !c.applies(outer, name, profile, runExec) {
+			return false
+		}
+	}
+
+	switch {
+	case block.Match != nil:
+		return c.matchCriteria(block.Match, name, profile, runExec)
+	case block.Patterns != nil:
+		return block.matches(name)
+	}
+	return true
+}
+
+// matchCriteria evaluates a Match block for the target name with the
+// settings resolved so far
+// Without runExec a block with an exec criterion never matches.
+func (c *SSHConfig) matchCriteria(criteria []sshMatchCriterion, name string, profile *Profile, runExec bool) bool {
+	for _, criterion := range criteria {
+		if criterion.Name == "exec" && !runExec {
+			return false
+		}
+	}
+
+	for _, criterion := range criteria {
+		var ok bool
+		switch criterion.Name {
+		case "all", "final":
+			ok = true
+		case "canonical":
+			// Host names are never canonicalized
+			ok = false
+		case "host":
+			host := cmp.Or(profile.Host, name)
+			ok = matchPatternList(strings.Split(criterion.Arg, ","), host)
+		case "originalhost":
+			ok = matchPatternList(strings.Split(criterion.Arg, ","), name)
+		case "user":
+			ok = matchPatternList(strings.Split(criterion.Arg, ","), cmp.Or(profile.User, localUserName()))
+		case "localuser":
+			ok = matchPatternList(strings.Split(criterion.Arg, ","), localUserName())
+		case "exec":
+			// The condition of an Include is checked for every block of the
+			// included file, but each command only runs once
+			command := c.expandTokens(criterion.Arg, name, profile)
+			result, done := c.execs[command]
+			if !done {
+				result = shellCommand(command).Run() == nil
+				c.execs[command] = result
+			}
+			ok = result
+		}
+
+		if ok == criterion.Negated {
+			return false
+		}
+	}
+	return true
+}
+
+// expandTokens expands %h (host), %n (original name), %p (port),
+// %r (remote user), %u (local user), %d (home directory) and %%
+func (c *SSHConfig) expandTokens(s, name string, profile *Profile) string {
+	replacer := strings.NewReplacer(
+		"%%", "%",
+		"%h", cmp.Or(profile.Host, name),
+		"%n", name,
+		"%p", cmp.Or(profile.Port, "22"),
+		"%r", cmp.Or(profile.User, localUserName()),
+		"%u", localUserName(),
+		"%d", c.home,
+	)
+	return replacer.Replace(s)
+}
+
+// localUserName returns the name of the user running sshclient
+func localUserName() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// names reports whether a matching block names the host: through a Host
+// pattern other than a catch-all "*", or a Match host/originalhost criterion
+func (b sshConfigBlock) names(name string) bool {
+	for _, pattern := range b.Patterns {
+		if pattern != "*" && !strings.HasPrefix(pattern, "!") && wildcardMatch(pattern, name) {
+			return true
+		}
+	}
+	for _, criterion := range b.Match {
+		if (criterion.Name == "host" || criterion.Name == "originalhost") && !criterion.Negated {
+			return true
+		}
+	}
+	return false
+}
+
+// sshConfigMultiValued lists the directives whose values accumulate over
+// all matching blocks, instead of the first value winning
+var sshConfigMultiValued = map[string]bool{
+	"identityfile":   true,
+	"localforward":   true,
+	"remoteforward":  true,
+	"dynamicforward": true,
+	"sendenv":        true,
+	"setenv":         true,
+}
+
+// Resolve returns the effective settings for a host name, as OpenSSH would
+// compute them: every matching block is evaluated in file order and the first
+// value found for a directive wins, so defaults from "Host *" at the end only
+// fill in what more specific blocks left unset. Directives that can be given
+// several times (IdentityFile, the forwards, SendEnv and SetEnv) accumulate.
+// found reports whether a block other than "Host *" matched the name.
+func (c *SSHConfig) Resolve(name string) (profile *Profile, found bool) {
+	return c.resolve(name, true)
+}
+
+// resolve implements Resolve; without runExec no "Match exec" command is
+// run and blocks using one are treated as not matching, which is how hosts
+// are resolved for listing, where running commands would be a surprise
+func (c *SSHConfig) resolve(name string, runExec bool) (profile *Profile, found bool) {
+	profile = &Profile{Name: name, FromSSHConfig: true}
+	seen := make(map[string]bool)
+	c.execs = make(map[string]bool)
+
+	for _, block := range c.blocks {
+		if !c.applies(block, name, profile, runExec) {
+			continue
+		}
+		if block.names(name) {
+			found = true
+		}
+
+		for _, option := range block.Options {
+			if seen[option.Key] {
+				continue
+			}
+			if !sshConfigMultiValued[option.Key] {
+				seen[option.Key] = true
+			}
+			if option.Key == "proxyjump" || option.Key == "proxycommand" {
+				// Whichever of the two comes first disables the other, as in OpenSSH
+				seen["proxyjump"], seen["proxycommand"] = true, true
+			}
+			if !c.apply(profile, name, option) && !slices.Contains(profile.IgnoredDirectives, option.Key) {
+				profile.IgnoredDirectives = append(profile.IgnoredDirectives, option.Key)
+			}
+		}
+	}
+
+	// If hostname is not set, use the host name
+	if profile.Host == "" {
+		profile.Host = name
+	}
+	if profile.Port == "" {
+		profile.Port = "22" // Default port
+	}
+
+	// Tokens refer to the final host, port and user, so they are expanded
+	// once everything is resolved. ProxyCommand is expanded when connecting.
+	expand := func(s string) string {
+		return c.expandPath(s, name, profile)
+	}
+	profile.Key = expand(profile.Key)
+	profile.Certificate = expand(profile.Certificate)
+	profile.KnownHostsFile = expand(profile.KnownHostsFile)
+	profile.RemoteCommand = c.expandTokens(profile.RemoteCommand, name, profile)
+	for _, list := range [][]string{profile.IdentityFiles, profile.Forwards, profile.RemoteForwards, profile.DynamicForwards} {
+		for i := range list {
+			list[i] = expand(list[i])
+		}
+	}
+
+	return profile, found
+}
+
+// expandPath expands tokens and a leading ~ in a value
+func (c *SSHConfig) expandPath(s, name string, profile *Profile) string {
+	s = c.expandTokens(s, name, profile)
+	if s == "~" || strings.HasPrefix(s, "~/") {
+		return filepath.Join(c.home, s[1:])
+	}
+	return s
+}
+
+// apply sets the profile field for a directive
+// It returns false for directives sshclient does not support.
+func (c *SSHConfig) apply(profile *Profile, name string, option sshConfigOption) bool {
+	value := option.Value
+
+	switch option.Key {
+	case "hostname":
+		profile.Host = strings.ReplaceAll(value, "%h", name)
+
+	case "user":
+		profile.User = value
+
+	case "port":
+		profile.Port = value
+
+	case "identityfile":
+		// The first IdentityFile is the profile's key, the others are extra keys
+		if profile.Key == "" {
+			profile.Key = value
+		} else {
+			profile.IdentityFiles = append(profile.IdentityFiles, value)
+		}
+
+	case "identitiesonly":
+		profile.IdentitiesOnly = isYes(value)
+
+	case "certificatefile":
+		profile.Certificate = value
+
+	case "proxyjump":
+		if strings.ToLower(value) != "none" {
+			profile.Jump = strings.Split(value, ",")
+		}
+
+	case "proxycommand":
+		if strings.ToLower(value) != "none" {
+			profile.ProxyCommand = value
+		}
+
+	case "localforward":
+		profile.Forwards = append(profile.Forwards, forwardSpec(value))
+
+	case "remoteforward":
+		profile.RemoteForwards = append(profile.RemoteForwards, forwardSpec(value))
+
+	case "dynamicforward":
+		profile.DynamicForwards = append(profile.DynamicForwards, value)
+
+	case "serveraliveinterval":
+		profile.ServerAliveInterval, _ = strconv.Atoi(value)
+
+	case "serveralivecountmax":
+		profile.ServerAliveCountMax, _ = strconv.Atoi(value)
+
+	case "connecttimeout":
+		profile.ConnectTimeout, _ = strconv.Atoi(value)
+
+	case "addressfamily":
+		profile.AddressFamily = strings.ToLower(value)
+
+	case "bindaddress":
+		profile.BindAddress = value
+
+	case "bindinterface":
+		profile.BindInterface = value
+
+	case "stricthostkeychecking":
+		profile.StrictHostKeyChecking = strings.ToLower(value)
+
+	case "userknownhostsfile":
+		// Only the first of several files is used; none means no file,
+		// which records nothing
+		files := strings.Fields(value)
+		switch {
+		case len(files) == 0:
+		case strings.ToLower(files[0]) == "none":
+			profile.KnownHostsFile = os.DevNull
+		default:
+			profile.KnownHostsFile = files[0]
+		}
+
+	case "hashknownhosts":
+		profile.HashKnownHosts = isYes(value)
+
+	case "updatehostkeys":
+		profile.UpdateHostKeys = strings.ToLower(value)
+
+	case "forwardagent":
+		profile.ForwardAgent = isYes(value)
+
+	case "sendenv":
+		profile.SendEnv = append(profile.SendEnv, strings.Fields(value)...)
+
+	case "setenv":
+		profile.SetEnv = append(profile.SetEnv, splitSetEnv(value)...)
+
+	case "requesttty":
+		profile.RequestTTY = strings.ToLower(value)
+
+	case "remotecommand":
+		profile.RemoteCommand = value
+
+	case "ciphers":
+		profile.Ciphers = value
+
+	case "kexalgorithms":
+		profile.KexAlgorithms = value
+
+	case "macs":
+		profile.MACs = value
+
+	case "hostkeyalgorithms":
+		profile.HostKeyAlgorithms = value
+
+	case "controlmaster":
+		// ask and autoask would need a confirmation prompt, treat them as no
+		mode := strings.ToLower(value)
+		profile.ControlMaster = mode == "yes" || mode == "auto"
+
+	case "controlpersist":
+		profile.ControlPersist = value
+
+	case "compression":
+		profile.Compression = isYes(value)
+
+	default:
+		return false
+	}
+
+	return true
+}
+
+// isYes reports whether a yes/no directive value is yes
+func isYes(value string) bool {
+	return strings.ToLower(value) == "yes"
+}
+
+// forwardSpec converts a LocalForward or RemoteForward value
+// ("[bind_address:]port host:hostport") to sshclient's -L/-R form
+func forwardSpec(value string) string {
+	return strings.Join(strings.Fields(value), ":")
+}
+
+// splitSetEnv splits a SetEnv value into NAME=value pairs; values may be
+// double-quoted to contain spaces
+func splitSetEnv(value string) []string {
+	_, _, pairs, err := splitSSHConfigLine("setenv " + value)
+	if err != nil {
+		return nil
+	}
+	return pairs
+}
+
+// Hosts returns the host names that appear literally in Host lines
+// (patterns with wildcards and negations are not names)
+func (c *SSHConfig) Hosts() []string {
+	seen := make(map[string]bool)
+	var hosts []string
+	for _, block := range c.blocks {
+		for _, pattern := range block.Patterns {
+			if strings.ContainsAny(pattern, "*?!") || seen[pattern] {
+				continue
+			}
+			seen[pattern] = true
+			hosts = append(hosts, pattern)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// ParseSSHConfig parses ~/.ssh/config file and returns a profile with the
+// effective settings for every host named in it
+func ParseSSHConfig() (map[string]Profile, error) {
+	config, err := LoadSSHConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	profiles := make(map[string]Profile)
+	for _, name := range config.Hosts() {
+		// Only connecting to a host runs its Match exec commands
+		profile, _ := config.resolve(name, false)
+		profiles[name] = *profile
+	}
+	return profiles, nil
+}
+
+// GetProfileFromSSHConfig retrieves a profile from SSH config
+// Any name matched by a Host pattern (e.g. "web-1" for "Host web-*") is found.
+func GetProfileFromSSHConfig(name string) (*Profile, error) {
+	config, err := LoadSSHConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	profile, found := config.Resolve(name)
+	if !found {
+		return nil, fmt.Errorf("host '%s' %w", name, errNotInSSHConfig)
+	}
+
+	return profile, nil
+}
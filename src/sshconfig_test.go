@@ -0,0 +1,296 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeSSHConfig creates ~/.ssh/config (and any other files under ~/.ssh)
+// in a temporary home directory and returns the home directory
+func writeSSHConfig(t *testing.T, files map[string]string) string {
+	t.Helper()
+
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	for name, content := range files {
+		path := filepath.Join(home, ".ssh", name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return home
+}
+
+func TestMatchExecOnlyRunsWhenConnecting(t *testing.T) {
+	home := writeSSHConfig(t, map[string]string{
+		"config": "Host a b c\n" +
+			"  User plain\n" +
+			"Match exec \"touch %d/ran-%n\"\n" +
+			"  Port 2200\n",
+	})
+	marker := func(name string) string { return filepath.Join(home, "ran-"+name) }
+
+	profiles, err := ParseSSHConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 3 {
+		t.Fatalf("ParseSSHConfig returned %d profiles, want 3", len(profiles))
+	}
+	for _, name := range []string{"a", "b", "c"} {
+		if _, err := os.Stat(marker(name)); err == nil {
+			t.Errorf("listing profiles ran the Match exec command for %s", name)
+		}
+		if port := profiles[name].Port; port != "22" {
+			t.Errorf("listed %s has port %s, want 22 (exec not evaluated)", name, port)
+		}
+	}
+
+	profile, err := GetProfileFromSSHConfig("b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker("b")); err != nil {
+		t.Error("resolving a host to connect to did not run its Match exec command")
+	}
+	if profile.Port != "2200" {
+		t.Errorf("resolved port = %s, want 2200", profile.Port)
+	}
+}
+
+func TestResolveFirstValueWins(t *testing.T) {
+	writeSSHConfig(t, map[string]string{
+		"config": "User global\n" +
+			"Host web-*\n" +
+			"  HostName %h.example.com\n" +
+			"  IdentityFile ~/.ssh/web\n" +
+			"Host web-1 !web-2\n" +
+			"  Port 2201\n" +
+			"  User ignored\n" +
+			"Host *\n" +
+			"  Port 22\n" +
+			"  IdentityFile ~/.ssh/default\n" +
+			"  LocalForward 8080 localhost:80\n",
+	})
+
+	config, err := LoadSSHConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		host     string
+		user     string
+		port     string
+		key      string
+		extra    int
+		forwards int
+		found    bool
+	}{
+		{name: "web-1", host: "web-1.example.com", user: "global", port: "2201", key: "web", extra: 1, forwards: 1, found: true},
+		{name: "web-2", host: "web-2.example.com", user: "global", port: "22", key: "web", extra: 1, forwards: 1, found: true},
+		{name: "db", host: "db", user: "global", port: "22", key: "default", forwards: 1, found: false},
+	}
+
+	for _, tt := range tests {
+		profile, found := config.Resolve(tt.name)
+		if found != tt.found {
+			t.Errorf("%s: found = %v, want %v", tt.name, found, tt.found)
+		}
+		if profile.Host != tt.host || profile.User != tt.user || profile.Port != tt.port {
+			t.Errorf("%s: resolved %s@%s:%s, want %s@%s:%s",
+				tt.name, profile.User, profile.Host, profile.Port, tt.user, tt.host, tt.port)
+		}
+		if filepath.Base(profile.Key) != tt.key || len(profile.IdentityFiles) != tt.extra {
+			t.Errorf("%s: key %s with %d extra keys, want %s with %d",
+				tt.name, profile.Key, len(profile.IdentityFiles), tt.key, tt.extra)
+		}
+		if len(profile.Forwards) != tt.forwards {
+			t.Errorf("%s: %d forwards, want %d", tt.name, len(profile.Forwards), tt.forwards)
+		}
+	}
+}
+
+func TestResolveMatch(t *testing.T) {
+	writeSSHConfig(t, map[string]string{
+		"config": "Host jump\n" +
+			"  HostName 10.0.0.1\n" +
+			"  User admin\n" +
+			"Match host 10.0.0.* user admin\n" +
+			"  Port 2222\n" +
+			"Match originalhost jump !user root\n" +
+			"  ForwardAgent yes\n" +
+			"Match exec \"exit 1\"\n" +
+			"  Compression yes\n" +
+			"Match all\n" +
+			"  ConnectTimeout 5\n",
+	})
+
+	config, err := LoadSSHConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profile, found := config.Resolve("jump")
+	if !found {
+		t.Fatal("jump not found")
+	}
+	if profile.Port != "2222" {
+		t.Errorf("Match host/user: port = %s, want 2222", profile.Port)
+	}
+	if !profile.ForwardAgent {
+		t.Error("Match originalhost with negated user did not apply")
+	}
+	if profile.Compression {
+		t.Error("Match exec with a failing command applied")
+	}
+	if profile.ConnectTimeout != 5 {
+		t.Errorf("Match all: ConnectTimeout = %d, want 5", profile.ConnectTimeout)
+	}
+
+	other, _ := config.Resolve("other")
+	if other.Port != "22" || other.ForwardAgent {
+		t.Errorf("Match blocks for jump applied to other: %+v", other)
+	}
+}
+
+func TestResolveInclude(t *testing.T) {
+	writeSSHConfig(t, map[string]string{
+		"config": "Include conf.d/*.conf\n" +
+			"Host prod\n" +
+			"  Include prod.inc\n" +
+			"  User produser\n" +
+			"Host *\n" +
+			"  User fallback\n",
+		"conf.d/a.conf": "Host alpha\n" +
+			"  Port 2001\n",
+		"conf.d/b.conf": "Host beta\n" +
+			"  Port 2002\n",
+		"prod.inc": "Port 2100\n" +
+			"Host alpha\n" +
+			"  HostName alpha-via-prod\n" +
+			"Host prod\n" +
+			"  HostName prod.example.com\n",
+	})
+
+	config, err := LoadSSHConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		host string
+		port string
+		user string
+	}{
+		{name: "alpha", host: "alpha", port: "2001", user: "fallback"},
+		{name: "beta", host: "beta", port: "2002", user: "fallback"},
+		// The rest of the Host block still applies after the Include
+		{name: "prod", host: "prod.example.com", port: "2100", user: "produser"},
+	}
+
+	for _, tt := range tests {
+		profile, found := config.Resolve(tt.name)
+		if !found {
+			t.Errorf("%s not found", tt.name)
+			continue
+		}
+		if profile.Host != tt.host || profile.Port != tt.port || profile.User != tt.user {
+			t.Errorf("%s: resolved %s@%s:%s, want %s@%s:%s",
+				tt.name, profile.User, profile.Host, profile.Port, tt.user, tt.host, tt.port)
+		}
+	}
+}
+
+func TestIncludeUnderInactiveBlock(t *testing.T) {
+	writeSSHConfig(t, map[string]string{
+		"config": "Match exec \"exit 1\"\n" +
+			"  Include inactive.inc\n" +
+			"Host *\n" +
+			"  Port 22\n",
+		"inactive.inc": "Host alpha\n" +
+			"  HostName should-not-apply\n" +
+			"Match all\n" +
+			"  User should-not-apply\n",
+	})
+
+	config, err := LoadSSHConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	profile, _ := config.Resolve("alpha")
+	if profile.Host != "alpha" || profile.User != "" {
+		t.Errorf("blocks included under an inactive Match applied: %s@%s", profile.User, profile.Host)
+	}
+}
+
+func TestIncludeNested(t *testing.T) {
+	writeSSHConfig(t, map[string]string{
+		"config": "Host *.corp\n" +
+			"  Include outer.inc\n",
+		"outer.inc": "Host db.*\n" +
+			"  Include inner.inc\n",
+		"inner.inc": "Port 5022\n",
+	})
+
+	config, err := LoadSSHConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		port string
+	}{
+		{name: "db.corp", port: "5022"},
+		{name: "web.corp", port: "22"},
+		{name: "db.home", port: "22"},
+	}
+	for _, tt := range tests {
+		profile, _ := config.Resolve(tt.name)
+		if profile.Port != tt.port {
+			t.Errorf("%s: port = %s, want %s", tt.name, profile.Port, tt.port)
+		}
+	}
+}
+
+func TestResolveUserKnownHostsFile(t *testing.T) {
+	writeSSHConfig(t, map[string]string{
+		"config": "Host empty\n" +
+			"  UserKnownHostsFile \"\"\n" +
+			"Host none\n" +
+			"  UserKnownHostsFile none\n" +
+			"Host several\n" +
+			"  UserKnownHostsFile /etc/ssh/first /etc/ssh/second\n",
+	})
+
+	tests := []struct {
+		name string
+		file string
+	}{
+		{name: "empty", file: ""},
+		{name: "none", file: os.DevNull},
+		{name: "several", file: "/etc/ssh/first"},
+	}
+	for _, tt := range tests {
+		profile, err := GetProfileFromSSHConfig(tt.name)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if profile.KnownHostsFile != tt.file {
+			t.Errorf("%s: KnownHostsFile = %q, want %q", tt.name, profile.KnownHostsFile, tt.file)
+		}
+	}
+
+	if _, err := ParseSSHConfig(); err != nil {
+		t.Errorf("ParseSSHConfig: %v", err)
+	}
+}
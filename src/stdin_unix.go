@@ -0,0 +1,30 @@
+//go:build !windows
+
+package main
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// waitReadable waits up to timeout for f to have input (or reach its end)
+// without reading it
+// select is used rather than poll, which does not support terminals on macOS.
+func waitReadable(f *os.File, timeout time.Duration) (bool, error) {
+	fd := int(f.Fd())
+	var set unix.FdSet
+	set.Set(fd)
+	tv := unix.NsecToTimeval(timeout.Nanoseconds())
+
+	n, err := unix.Select(fd+1, &set, nil, nil, &tv)
+	if errors.Is(err, unix.EINTR) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}